@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/ktt-ol/sesam/internal/conf"
 	"github.com/ktt-ol/sesam/internal/mqtt"
@@ -8,29 +9,55 @@ import (
 	"github.com/ktt-ol/sesam/internal/wikiauth"
 	"github.com/sirupsen/logrus"
 	"os"
+	"time"
 )
 
 var buildVersion = "unkown"
 
 func main() {
+	rotateKeys := flag.Bool("rotate-keys", false, "adds new keys to the keys file and exits, restart sesam afterwards")
+	flag.Parse()
+
 	config := conf.LoadConfig("config.toml")
 	setupLogging(config.Logging)
 
+	if *rotateKeys {
+		// the old keys are kept as long as a remembered login is valid
+		keepOldFor := time.Duration(web.REMEMBER_PASSWORD_DAYS) * 24 * time.Hour
+		keys, err := conf.RotateKeys(config.Server.KeysFile, keepOldFor)
+		if err != nil {
+			logrus.WithError(err).Fatal("Can't rotate the keys.")
+		}
+		logrus.WithField("generations", len(keys.Generations)).Info("New keys created, restart sesam to use them.")
+		return
+	}
+
 	logrus.WithFields(logrus.Fields{
 		"mqttUrl":  config.Mqtt.Url,
 		"mqttUser": config.Mqtt.Username,
 		"serving":  fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
 		"https":    config.Server.Https,
+		"auth":     wikiauth.BackendName(config.Auth),
 		"version":  buildVersion,
 	}).Info("Sesam is starting...")
 
-	//auth := wikiauth.NewLocalFilesAuth(&config.AuthLocal)
-	auth := wikiauth.NewOnlineAuth(&config.AuthOnline)
+	auth, err := wikiauth.NewWikiAuth(&config)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid auth config.")
+	}
 
 	//mqtt.EnableMqttDebugLogging()
-	mqttHandler := mqtt.NewMqttHandler(config.Mqtt)
+	statusPolicy, err := mqtt.NewStatusPolicy(config.Status)
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid status config.")
+	}
+	doors, err := config.DoorList()
+	if err != nil {
+		logrus.WithError(err).Fatal("Invalid door config.")
+	}
+	mqttHandler := mqtt.NewMqttHandler(config.Mqtt, statusPolicy, doors)
 
-	web.StartWeb(config.Server, auth, mqttHandler)
+	web.StartWeb(config, auth, mqttHandler)
 }
 
 type StdErrLogHook struct {
@@ -0,0 +1,127 @@
+package access
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/wikiauth"
+	"github.com/sirupsen/logrus"
+)
+
+var logger = logrus.WithField("where", "access")
+
+// Groups knows the members of the configured groups. The members are read from wiki group pages and/or listed in
+// the config. A changed group page is read again with the next membership check.
+type Groups struct {
+	mux    sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	pageFile string
+	users    []string
+	// set of user names, the page members and the users
+	members map[string]struct{}
+	// of the page file when it was read, to notice changes
+	pageModTime time.Time
+	pageSize    int64
+}
+
+func NewGroups(config []conf.GroupConf) (*Groups, error) {
+	groups := Groups{groups: make(map[string]*group)}
+	for _, groupConf := range config {
+		if groupConf.Name == "" {
+			return nil, fmt.Errorf("a group without a name")
+		}
+		if _, exists := groups.groups[groupConf.Name]; exists {
+			return nil, fmt.Errorf("the group '%s' is defined twice", groupConf.Name)
+		}
+
+		newGroup := group{pageFile: groupConf.GroupPageFile, users: groupConf.Users}
+		if err := newGroup.read(); err != nil {
+			return nil, fmt.Errorf("can't read the group page of '%s': %s", groupConf.Name, err)
+		}
+		groups.groups[groupConf.Name] = &newGroup
+	}
+
+	return &groups, nil
+}
+
+// Exists returns true if the group is defined.
+func (g *Groups) Exists(group string) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	_, ok := g.groups[group]
+	return ok
+}
+
+// IsMember returns true if the user is a member of the group.
+func (g *Groups) IsMember(user string, groupName string) bool {
+	g.mux.Lock()
+	defer g.mux.Unlock()
+
+	found, ok := g.groups[groupName]
+	if !ok {
+		return false
+	}
+	found.refresh(groupName)
+	_, ok = found.members[user]
+	return ok
+}
+
+// IsMemberOfAny returns true if the user is a member of at least one of the groups.
+func (g *Groups) IsMemberOfAny(user string, groups []string) bool {
+	for _, group := range groups {
+		if g.IsMember(user, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh reads the group page again if it has changed. On errors the old members are kept.
+func (g *group) refresh(groupName string) {
+	if g.pageFile == "" {
+		return
+	}
+	info, err := os.Stat(g.pageFile)
+	if err != nil {
+		logger.WithError(err).WithField("group", groupName).Error("Can't check the group page, keeping the members.")
+		return
+	}
+	if info.ModTime().Equal(g.pageModTime) && info.Size() == g.pageSize {
+		return
+	}
+	if err := g.read(); err != nil {
+		logger.WithError(err).WithField("group", groupName).Error("Can't read the group page, keeping the members.")
+		return
+	}
+	logger.WithField("group", groupName).Info("group page changed")
+}
+
+func (g *group) read() error {
+	members := make(map[string]struct{})
+	if g.pageFile != "" {
+		// the stat before the read, a change in between is noticed with the next check
+		info, err := os.Stat(g.pageFile)
+		if err != nil {
+			return err
+		}
+		pageMembers, err := wikiauth.ReadGroupPage(g.pageFile)
+		if err != nil {
+			return err
+		}
+		members = pageMembers
+		g.pageModTime = info.ModTime()
+		g.pageSize = info.Size()
+	}
+	for _, user := range g.users {
+		members[user] = struct{}{}
+	}
+	g.members = members
+	return nil
+}
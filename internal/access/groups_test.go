@@ -0,0 +1,38 @@
+package access
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Groups_pageChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	groupPage, err := ioutil.TempFile("", "groupPage")
+	assert.NoError(err)
+	defer os.Remove(groupPage.Name())
+	groupPage.WriteString(" * alice\n * bob\n")
+	groupPage.Close()
+
+	groups, err := NewGroups([]conf.GroupConf{{Name: "members", GroupPageFile: groupPage.Name(), Users: []string{"carol"}}})
+	assert.NoError(err)
+	assert.True(groups.IsMember("bob", "members"))
+	assert.True(groups.IsMember("carol", "members"))
+
+	assert.NoError(ioutil.WriteFile(groupPage.Name(), []byte(" * alice\n * dave\n"), 0600))
+	// the same second on file systems with a coarse mod time
+	later := time.Now().Add(time.Minute)
+	assert.NoError(os.Chtimes(groupPage.Name(), later, later))
+	assert.False(groups.IsMember("bob", "members"))
+	assert.True(groups.IsMember("dave", "members"))
+	assert.True(groups.IsMember("carol", "members"))
+
+	// a broken page keeps the members
+	assert.NoError(os.Remove(groupPage.Name()))
+	assert.True(groups.IsMember("dave", "members"))
+}
@@ -0,0 +1,172 @@
+package access
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// the time windows are searched this far ahead for the next allowed time
+const maxScheduleLookAhead = 8 * 24 * time.Hour
+
+// Permissions decides which user may open which door and when. Without any grant in the config, every user may open
+// every door.
+type Permissions struct {
+	groups   *Groups
+	grants   []grant
+	location *time.Location
+	// door id -> schedule, only doors with a schedule
+	doorSchedules map[string]Schedule
+}
+
+type grant struct {
+	conf.GrantConf
+	schedule Schedule
+}
+
+// NewPermissions validates the grants and schedules against the groups and the known door ids.
+func NewPermissions(config conf.PermissionsConf, doorIds []string) (*Permissions, error) {
+	groups, err := NewGroups(config.Groups)
+	if err != nil {
+		return nil, err
+	}
+
+	location := time.Local
+	if config.Timezone != "" {
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %s", err)
+		}
+	}
+
+	knownDoors := make(map[string]struct{})
+	for _, id := range doorIds {
+		knownDoors[id] = struct{}{}
+	}
+
+	permissions := Permissions{groups: groups, location: location, doorSchedules: make(map[string]Schedule)}
+	for _, grantConf := range config.Grants {
+		for _, group := range grantConf.Groups {
+			if !groups.Exists(group) {
+				return nil, fmt.Errorf("unknown group '%s' in a grant", group)
+			}
+		}
+		for _, door := range grantConf.Doors {
+			if _, ok := knownDoors[door]; !ok {
+				return nil, fmt.Errorf("unknown door '%s' in a grant", door)
+			}
+		}
+		schedule, err := ParseSchedule(grantConf.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		permissions.grants = append(permissions.grants, grant{grantConf, schedule})
+	}
+
+	for _, doorSchedule := range config.DoorSchedules {
+		if _, ok := knownDoors[doorSchedule.Door]; !ok {
+			return nil, fmt.Errorf("unknown door '%s' in a door schedule", doorSchedule.Door)
+		}
+		schedule, err := ParseSchedule(doorSchedule.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		permissions.doorSchedules[doorSchedule.Door] = schedule
+	}
+
+	return &permissions, nil
+}
+
+// Groups returns the configured groups.
+func (p *Permissions) Groups() *Groups {
+	return p.groups
+}
+
+// MayOpen returns true if the user is granted the door, regardless of the time.
+func (p *Permissions) MayOpen(user string, door string) bool {
+	if len(p.grants) == 0 {
+		return true
+	}
+
+	for _, grant := range p.grants {
+		if grantsDoor(grant, door) && p.isGranted(grant, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// MayOpenAt returns true if the user may open the door at the given time.
+func (p *Permissions) MayOpenAt(user string, door string, t time.Time) bool {
+	t = t.In(p.location)
+	if schedule, ok := p.doorSchedules[door]; ok && !schedule.Contains(t) {
+		return false
+	}
+	if len(p.grants) == 0 {
+		return true
+	}
+
+	for _, grant := range p.grants {
+		if grantsDoor(grant, door) && p.isGranted(grant, user) && grant.schedule.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextAllowed returns the start of the next time window after t, in which the user may open the door. ok is false if
+// there is no such window within the next week.
+func (p *Permissions) NextAllowed(user string, door string, t time.Time) (next time.Time, ok bool) {
+	// the windows have a resolution of minutes
+	first := t.In(p.location).Truncate(time.Minute).Add(time.Minute)
+	if p.MayOpenAt(user, door, first) {
+		return first, true
+	}
+
+	// the permission only changes at the start or the end of a window, so only these times are checked
+	end := t.Add(maxScheduleLookAhead)
+	var candidates []time.Time
+	for _, schedule := range p.schedules(user, door) {
+		candidates = append(candidates, schedule.boundaries(first, end)...)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, candidate := range candidates {
+		if candidate.After(first) && candidate.Before(end) && p.MayOpenAt(user, door, candidate) {
+			return candidate, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// schedules returns the schedule of the door and those of the grants of the user for the door
+func (p *Permissions) schedules(user string, door string) []Schedule {
+	var schedules []Schedule
+	if schedule, ok := p.doorSchedules[door]; ok {
+		schedules = append(schedules, schedule)
+	}
+	for _, grant := range p.grants {
+		if grantsDoor(grant, door) && p.isGranted(grant, user) {
+			schedules = append(schedules, grant.schedule)
+		}
+	}
+	return schedules
+}
+
+func (p *Permissions) isGranted(grant grant, user string) bool {
+	for _, grantUser := range grant.Users {
+		if grantUser == user {
+			return true
+		}
+	}
+	return p.groups.IsMemberOfAny(user, grant.Groups)
+}
+
+func grantsDoor(grant grant, door string) bool {
+	for _, grantDoor := range grant.Doors {
+		if grantDoor == door {
+			return true
+		}
+	}
+	return false
+}
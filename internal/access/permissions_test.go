@@ -0,0 +1,62 @@
+package access
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+var testDoors = []string{"outer", "innerGlass", "innerMetal"}
+
+func Test_Permissions_noGrants(t *testing.T) {
+	assert := assert.New(t)
+
+	permissions, err := NewPermissions(conf.PermissionsConf{}, testDoors)
+	assert.NoError(err)
+	assert.True(permissions.MayOpen("anybody", "outer"))
+}
+
+func Test_Permissions_grants(t *testing.T) {
+	assert := assert.New(t)
+
+	groupPage, err := ioutil.TempFile("", "groupPage")
+	assert.NoError(err)
+	defer os.Remove(groupPage.Name())
+	groupPage.WriteString("#acl All:read\n * alice\n * bob\n")
+	groupPage.Close()
+
+	permissions, err := NewPermissions(conf.PermissionsConf{
+		Groups: []conf.GroupConf{
+			{Name: "members", GroupPageFile: groupPage.Name()},
+			{Name: "guests", Users: []string{"carol"}},
+		},
+		Grants: []conf.GrantConf{
+			{Groups: []string{"members"}, Doors: testDoors},
+			{Groups: []string{"guests"}, Users: []string{"dave"}, Doors: []string{"outer"}},
+		},
+	}, testDoors)
+	assert.NoError(err)
+
+	assert.True(permissions.MayOpen("alice", "innerMetal"))
+	assert.True(permissions.MayOpen("carol", "outer"))
+	assert.False(permissions.MayOpen("carol", "innerMetal"))
+	assert.True(permissions.MayOpen("dave", "outer"))
+	assert.False(permissions.MayOpen("eve", "outer"))
+}
+
+func Test_Permissions_invalidConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewPermissions(conf.PermissionsConf{
+		Grants: []conf.GrantConf{{Groups: []string{"unknown"}, Doors: testDoors}},
+	}, testDoors)
+	assert.Error(err)
+
+	_, err = NewPermissions(conf.PermissionsConf{
+		Grants: []conf.GrantConf{{Users: []string{"alice"}, Doors: []string{"backdoor"}}},
+	}, testDoors)
+	assert.Error(err)
+}
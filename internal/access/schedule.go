@@ -0,0 +1,159 @@
+package access
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a list of weekly time windows. An empty schedule contains every point in time.
+type Schedule []window
+
+type window struct {
+	days [7]bool
+	// minutes of the day, if end <= start the window ends on the next day
+	start int
+	end   int
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses windows like "Mon-Fri 08:00-18:00", "Sat,Sun 10:00-14:00", "* 22:00-02:00".
+func ParseSchedule(specs []string) (Schedule, error) {
+	schedule := make(Schedule, 0, len(specs))
+	for _, spec := range specs {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %s", spec, err)
+		}
+		schedule = append(schedule, w)
+	}
+	return schedule, nil
+}
+
+func parseWindow(spec string) (window, error) {
+	var w window
+	fields := strings.Fields(spec)
+	if len(fields) != 2 {
+		return w, fmt.Errorf("expected '<days> <HH:MM>-<HH:MM>'")
+	}
+
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, err
+	}
+
+	times := strings.Split(fields[1], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("expected '<HH:MM>-<HH:MM>'")
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, err
+	}
+	if w.end, err = parseTimeOfDay(times[1]); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("empty time range")
+	}
+
+	return w, nil
+}
+
+func (w *window) parseDays(spec string) error {
+	if spec == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		bounds := strings.Split(part, "-")
+		if len(bounds) > 2 {
+			return fmt.Errorf("invalid days '%s'", part)
+		}
+		from, ok := weekdayNames[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day '%s'", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdayNames[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day '%s'", bounds[1])
+			}
+		}
+		// ranges can wrap, e.g. Sat-Mon
+		for day := from; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// the end of a day, time.Parse doesn't accept it
+const endOfDay = "24:00"
+
+// parseTimeOfDay returns the minutes of the day of "HH:MM"
+func parseTimeOfDay(spec string) (int, error) {
+	if spec == endOfDay {
+		return 24 * 60, nil
+	}
+	parsed, err := time.Parse("15:04", spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected HH:MM", spec)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Contains returns true if t is within one of the windows. The weekday and time of day are taken in the location
+// of t.
+func (s Schedule) Contains(t time.Time) bool {
+	if len(s) == 0 {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+	for _, w := range s {
+		if w.start < w.end {
+			if w.days[today] && minute >= w.start && minute < w.end {
+				return true
+			}
+			continue
+		}
+		// the window wraps at midnight
+		if (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// boundaries returns the starts and ends of the windows between from and to, in the location of from. Days that are
+// not in a window are included, too.
+func (s Schedule) boundaries(from time.Time, to time.Time) []time.Time {
+	var result []time.Time
+	// a window of yesterday can end today
+	day := time.Date(from.Year(), from.Month(), from.Day()-1, 0, 0, 0, 0, from.Location())
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, w := range s {
+			result = append(result,
+				time.Date(day.Year(), day.Month(), day.Day(), 0, w.start, 0, 0, day.Location()),
+				time.Date(day.Year(), day.Month(), day.Day(), 0, w.end, 0, 0, day.Location()))
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package access
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// 2019-06-03 is a monday
+func testTime(day int, hour int, minute int) time.Time {
+	return time.Date(2019, 6, day, hour, minute, 0, 0, time.UTC)
+}
+
+func Test_Schedule_Contains(t *testing.T) {
+	assert := assert.New(t)
+
+	schedule, err := ParseSchedule([]string{"Mon-Fri 08:00-18:00", "Sat 22:00-02:00"})
+	assert.NoError(err)
+
+	assert.True(schedule.Contains(testTime(3, 8, 0)))
+	assert.True(schedule.Contains(testTime(7, 17, 59)))
+	assert.False(schedule.Contains(testTime(7, 18, 0)))
+	assert.False(schedule.Contains(testTime(3, 7, 59)))
+	// saturday night until sunday morning
+	assert.True(schedule.Contains(testTime(8, 23, 0)))
+	assert.True(schedule.Contains(testTime(9, 1, 59)))
+	assert.False(schedule.Contains(testTime(9, 2, 0)))
+	// sunday night is not in the schedule
+	assert.False(schedule.Contains(testTime(9, 23, 0)))
+
+	assert.True(Schedule{}.Contains(testTime(9, 23, 0)))
+}
+
+func Test_ParseSchedule_invalid(t *testing.T) {
+	for _, spec := range []string{"Mon", "Mon 08:00", "Foo 08:00-10:00", "Mon 08:00-25:00", "Mon 08:00-08:00",
+		"Mon 08:00-10:00junk", "Mon 08:00:30-10:00", "Mon 08:0-10:00", "Mon 08:60-10:00", "Mon 8-10"} {
+		_, err := ParseSchedule([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func Test_Permissions_schedule(t *testing.T) {
+	assert := assert.New(t)
+
+	permissions, err := NewPermissions(conf.PermissionsConf{
+		Timezone: "UTC",
+		Grants: []conf.GrantConf{
+			{Users: []string{"alice"}, Doors: testDoors},
+			{Users: []string{"cleaner"}, Doors: []string{"outer"}, Schedule: []string{"Mon,Thu 08:00-10:00"}},
+		},
+		DoorSchedules: []conf.DoorScheduleConf{{Door: "innerMetal", Schedule: []string{"* 06:00-22:00"}}},
+	}, testDoors)
+	assert.NoError(err)
+
+	assert.True(permissions.MayOpenAt("cleaner", "outer", testTime(3, 9, 0)))
+	assert.False(permissions.MayOpenAt("cleaner", "outer", testTime(4, 9, 0)))
+	next, ok := permissions.NextAllowed("cleaner", "outer", testTime(4, 9, 0))
+	assert.True(ok)
+	assert.Equal(testTime(6, 8, 0), next)
+
+	assert.True(permissions.MayOpenAt("alice", "outer", testTime(4, 23, 0)))
+	assert.False(permissions.MayOpenAt("alice", "innerMetal", testTime(4, 23, 0)))
+	next, ok = permissions.NextAllowed("alice", "innerMetal", testTime(4, 23, 0))
+	assert.True(ok)
+	assert.Equal(testTime(5, 6, 0), next)
+
+	_, ok = permissions.NextAllowed("bob", "outer", testTime(4, 23, 0))
+	assert.False(ok)
+}
+
+func Test_Permissions_NextAllowed_likeEveryMinute(t *testing.T) {
+	assert := assert.New(t)
+
+	permissions, err := NewPermissions(conf.PermissionsConf{
+		Timezone: "Europe/Berlin",
+		Grants: []conf.GrantConf{
+			{Users: []string{"alice"}, Doors: []string{"outer"}, Schedule: []string{"Sat 22:00-02:00", "Tue 00:00-00:30"}},
+			{Users: []string{"alice"}, Doors: []string{"outer"}, Schedule: []string{"Wed-Thu 17:15-24:00"}},
+		},
+		DoorSchedules: []conf.DoorScheduleConf{{Door: "outer", Schedule: []string{"Mon-Fri 08:00-23:00", "Sun 01:00-03:00"}}},
+	}, testDoors)
+	assert.NoError(err)
+
+	// the old search, one minute after the other
+	everyMinute := func(t time.Time) (time.Time, bool) {
+		for next := t.Truncate(time.Minute).Add(time.Minute); next.Before(t.Add(maxScheduleLookAhead)); next = next.Add(time.Minute) {
+			if permissions.MayOpenAt("alice", "outer", next) {
+				return next, true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	// over a daylight saving time change
+	for at := time.Date(2019, 3, 28, 0, 7, 30, 0, time.UTC); at.Before(time.Date(2019, 4, 6, 0, 0, 0, 0, time.UTC)); at = at.Add(37 * time.Minute) {
+		expected, expectedOk := everyMinute(at)
+		next, ok := permissions.NextAllowed("alice", "outer", at)
+		assert.Equal(expectedOk, ok, at.String())
+		assert.True(expected.Equal(next), "%s: %s != %s", at, expected, next)
+	}
+}
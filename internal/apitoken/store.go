@@ -0,0 +1,204 @@
+// Package apitoken keeps the personal API tokens of the users. Only a hash of the secret is stored, the token itself
+// is shown once after it was created.
+package apitoken
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/jsonfile"
+	"github.com/sirupsen/logrus"
+)
+
+// every token starts with this prefix, it makes leaked tokens easy to find
+const tokenPrefix = "sesam_"
+
+const idLength = 9
+const secretLength = 32
+
+// the last use is written to the file at most this often
+const lastUsedPrecision = time.Minute
+
+var logger = logrus.WithField("where", "apitoken")
+
+// Token is an API token of a user, without the secret
+type Token struct {
+	Id   string
+	User string
+	// chosen by the user, e.g. "home automation"
+	Name string
+	// the doors the token may open
+	Doors   []string
+	Created time.Time
+	// zero if the token doesn't expire
+	Expires  time.Time
+	LastUsed time.Time
+	// sha256 of the secret, hex encoded
+	Hash string
+}
+
+// MayOpen returns true if the door is in the scope of the token
+func (t Token) MayOpen(doorId string) bool {
+	for _, door := range t.Doors {
+		if door == doorId {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExpired returns true if the token is not valid anymore at the given time
+func (t Token) IsExpired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
+
+// Store contains the tokens, by id
+type Store struct {
+	file   string
+	mux    sync.Mutex
+	tokens map[string]*Token
+	// replaceable for tests
+	now func() time.Time
+}
+
+// NewStore loads the tokens of the file
+func NewStore(file string) (*Store, error) {
+	store := Store{
+		file:   file,
+		tokens: make(map[string]*Token),
+		now:    time.Now,
+	}
+	if err := jsonfile.Load(file, &store.tokens); err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// Create adds a new token for the user and returns it with the secret. The secret can't be restored later. A zero
+// expires means the token never expires.
+func (s *Store) Create(userName string, name string, doors []string, expires time.Time) (string, Token) {
+	id := base64.RawURLEncoding.EncodeToString(conf.GenerateRandomBytes(idLength))
+	secret := base64.RawURLEncoding.EncodeToString(conf.GenerateRandomBytes(secretLength))
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+	token := Token{
+		Id:      id,
+		User:    userName,
+		Name:    name,
+		Doors:   append([]string{}, doors...),
+		Created: now,
+		Expires: expires,
+		Hash:    hashSecret(secret),
+	}
+	s.tokens[id] = &token
+	s.saveLocked()
+
+	return tokenPrefix + id + "." + secret, token
+}
+
+// ParseId returns the id part of the token, e.g. for the logging. It doesn't check the token.
+func ParseId(value string) (string, bool) {
+	if !strings.HasPrefix(value, tokenPrefix) {
+		return "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, tokenPrefix), ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// Authenticate returns the token of the value, if the secret matches and the token is not expired. The last use is
+// updated.
+func (s *Store) Authenticate(value string) (Token, bool) {
+	id, ok := ParseId(value)
+	if !ok {
+		return Token{}, false
+	}
+	secret := value[len(tokenPrefix)+len(id)+1:]
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(secret))) != 1 {
+		return Token{}, false
+	}
+	now := s.now()
+	if token.IsExpired(now) {
+		return Token{}, false
+	}
+
+	if now.Sub(token.LastUsed) >= lastUsedPrecision {
+		token.LastUsed = now
+		s.saveLocked()
+	}
+	return *token, true
+}
+
+// List returns the tokens of the user, the newest first
+func (s *Store) List(userName string) []Token {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var list []Token
+	for _, token := range s.tokens {
+		if token.User == userName {
+			list = append(list, *token)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list
+}
+
+// Revoke deletes a token of the user
+func (s *Store) Revoke(userName string, id string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.User != userName {
+		return false
+	}
+	delete(s.tokens, id)
+	s.saveLocked()
+	return true
+}
+
+// RevokeUser deletes all tokens of the user, e.g. if the user is not a member anymore
+func (s *Store) RevokeUser(userName string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	changed := false
+	for id, token := range s.tokens {
+		if token.User == userName {
+			delete(s.tokens, id)
+			changed = true
+		}
+	}
+	if changed {
+		s.saveLocked()
+	}
+}
+
+func (s *Store) saveLocked() {
+	if err := jsonfile.Save(s.file, s.tokens); err != nil {
+		logger.WithError(err).WithField("file", s.file).Error("Can't save the API tokens.")
+	}
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package apitoken
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Store_createAndAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "tokens.json"))
+	assert.NoError(err)
+
+	value, token := store.Create("alice", "nfc tag", []string{"outer"}, time.Time{})
+	assert.True(strings.HasPrefix(value, "sesam_"+token.Id+"."))
+	// only the hash of the secret is stored
+	assert.Equal(hashSecret(value[len("sesam_"+token.Id+"."):]), token.Hash)
+
+	found, ok := store.Authenticate(value)
+	assert.True(ok)
+	assert.Equal("alice", found.User)
+	assert.True(found.MayOpen("outer"))
+	assert.False(found.MayOpen("innerGlass"))
+
+	_, ok = store.Authenticate(value + "x")
+	assert.False(ok)
+	_, ok = store.Authenticate("sesam_unknown.secret")
+	assert.False(ok)
+	_, ok = store.Authenticate("")
+	assert.False(ok)
+
+	// the tokens survive a restart
+	reloaded, err := NewStore(store.file)
+	assert.NoError(err)
+	_, ok = reloaded.Authenticate(value)
+	assert.True(ok)
+}
+
+func Test_Store_expires(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "tokens.json"))
+	assert.NoError(err)
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	store.now = testutil.Clock(&now)
+
+	value, _ := store.Create("alice", "shortcut", []string{"outer"}, now.Add(time.Hour))
+	found, ok := store.Authenticate(value)
+	assert.True(ok)
+	assert.Equal(now, found.LastUsed)
+
+	now = now.Add(time.Hour)
+	_, ok = store.Authenticate(value)
+	assert.False(ok)
+}
+
+func Test_Store_revoke(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "tokens.json"))
+	assert.NoError(err)
+
+	value, token := store.Create("alice", "a", []string{"outer"}, time.Time{})
+	store.Create("alice", "b", []string{"outer"}, time.Time{})
+	bobValue, _ := store.Create("bob", "c", []string{"outer"}, time.Time{})
+	assert.Len(store.List("alice"), 2)
+
+	// only the own tokens
+	assert.False(store.Revoke("bob", token.Id))
+	assert.True(store.Revoke("alice", token.Id))
+	_, ok := store.Authenticate(value)
+	assert.False(ok)
+	assert.Len(store.List("alice"), 1)
+
+	store.RevokeUser("alice")
+	assert.Empty(store.List("alice"))
+	_, ok = store.Authenticate(bobValue)
+	assert.True(ok)
+}
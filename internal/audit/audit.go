@@ -0,0 +1,261 @@
+// Package audit stores door openings and logins in an append-only log. Every day gets its own file with one json
+// entry per line.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/sirupsen/logrus"
+)
+
+const KindBuzz = "buzz"
+const KindLogin = "login"
+
+const ResultOk = "ok"
+const ResultDenied = "denied"
+const ResultFailed = "failed"
+const ResultError = "error"
+
+const filePrefix = "audit-"
+const fileSuffix = ".jsonl"
+const dayLayout = "2006-01-02"
+
+var logger = logrus.WithField("where", "audit")
+
+type Entry struct {
+	Time time.Time
+	// KindBuzz or KindLogin
+	Kind string
+	// the user name, for failed logins the entered login
+	User        string
+	Door        string `json:",omitempty"`
+	Ip          string
+	SpaceStatus string `json:",omitempty"`
+	// one of the Result constants
+	Result string
+	// optional, e.g. the reason of a failure
+	Detail string `json:",omitempty"`
+}
+
+// Filter for Query, empty fields match everything
+type Filter struct {
+	Kind string
+	User string
+	Door string
+	// inclusive
+	From time.Time
+	// exclusive
+	To time.Time
+	// max number of entries, 0 = no limit
+	Limit int
+}
+
+// Log is the audit store. A nil *Log is a disabled log, all methods can be called.
+type Log struct {
+	dir           string
+	retentionDays int
+	mux           sync.Mutex
+	file          *os.File
+	fileDay       string
+	// replaceable for tests
+	now func() time.Time
+}
+
+// NewLog returns nil if the audit log is disabled in the config.
+func NewLog(config conf.AuditConf) (*Log, error) {
+	if config.Directory == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(config.Directory, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Log{dir: config.Directory, retentionDays: config.RetentionDays, now: time.Now}, nil
+}
+
+// Add appends the entry. The time is set if missing.
+func (l *Log) Add(entry Entry) {
+	if l == nil {
+		return
+	}
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if entry.Time.IsZero() {
+		entry.Time = l.now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logger.WithError(err).Error("Can't encode the entry.")
+		return
+	}
+
+	if err := l.openFile(entry.Time); err != nil {
+		logger.WithError(err).Error("Can't open the audit file.")
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		logger.WithError(err).Error("Can't write the audit entry.")
+	}
+}
+
+// openFile opens the file of the day and removes the expired files, must be called with the lock held
+func (l *Log) openFile(t time.Time) error {
+	day := t.Format(dayLayout)
+	if l.file != nil && l.fileDay == day {
+		return nil
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+
+	file, err := os.OpenFile(l.dayFile(day), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.fileDay = day
+
+	l.removeExpired(t)
+	return nil
+}
+
+func (l *Log) removeExpired(now time.Time) {
+	if l.retentionDays <= 0 {
+		return
+	}
+
+	oldest := now.AddDate(0, 0, -l.retentionDays).Format(dayLayout)
+	days, err := l.days()
+	if err != nil {
+		logger.WithError(err).Error("Can't list the audit files.")
+		return
+	}
+	for _, day := range days {
+		if day < oldest {
+			if err := os.Remove(l.dayFile(day)); err != nil {
+				logger.WithError(err).Error("Can't remove an old audit file.")
+			}
+		}
+	}
+}
+
+// Query returns the matching entries, the newest first.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	days, err := l.days()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []Entry
+	// newest day first
+	for i := len(days) - 1; i >= 0; i-- {
+		day := days[i]
+		if !filter.From.IsZero() && day < filter.From.Format(dayLayout) {
+			break
+		}
+		if !filter.To.IsZero() && day > filter.To.Format(dayLayout) {
+			continue
+		}
+
+		entries, err := l.readDay(day)
+		if err != nil {
+			return nil, err
+		}
+		for j := len(entries) - 1; j >= 0; j-- {
+			if !filter.matches(entries[j]) {
+				continue
+			}
+			result = append(result, entries[j])
+			if filter.Limit > 0 && len(result) >= filter.Limit {
+				return result, nil
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func (l *Log) readDay(day string) ([]Entry, error) {
+	// don't read while a line is written
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	file, err := os.Open(l.dayFile(day))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.WithError(err).WithField("day", day).Warn("Skipping an invalid audit line.")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	// the entries are appended, but the clock might have been changed
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	return entries, scanner.Err()
+}
+
+// days returns the days with an audit file, sorted ascending
+func (l *Log) days() ([]string, error) {
+	files, err := ioutil.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		days = append(days, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix))
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+func (l *Log) dayFile(day string) string {
+	return filepath.Join(l.dir, filePrefix+day+fileSuffix)
+}
+
+func (f *Filter) matches(entry Entry) bool {
+	if f.Kind != "" && f.Kind != entry.Kind {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(f.User, entry.User) {
+		return false
+	}
+	if f.Door != "" && f.Door != entry.Door {
+		return false
+	}
+	if !f.From.IsZero() && entry.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !entry.Time.Before(f.To) {
+		return false
+	}
+	return true
+}
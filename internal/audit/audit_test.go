@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Log_addAndQuery(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	log, err := NewLog(conf.AuditConf{Directory: dir})
+	assert.NoError(err)
+
+	day1 := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	log.Add(Entry{Time: day1, Kind: KindLogin, User: "alice", Result: ResultOk})
+	log.Add(Entry{Time: day1.Add(time.Minute), Kind: KindBuzz, User: "alice", Door: "outer", Result: ResultOk})
+	log.Add(Entry{Time: day2, Kind: KindBuzz, User: "bob", Door: "innerGlass", Result: ResultDenied})
+
+	all, err := log.Query(Filter{})
+	assert.NoError(err)
+	assert.Len(all, 3)
+	// newest first
+	assert.Equal("bob", all[0].User)
+	assert.Equal(KindLogin, all[2].Kind)
+
+	buzzes, err := log.Query(Filter{Kind: KindBuzz, User: "ALICE"})
+	assert.NoError(err)
+	assert.Len(buzzes, 1)
+	assert.Equal("outer", buzzes[0].Door)
+
+	firstDay, err := log.Query(Filter{From: day1, To: day2})
+	assert.NoError(err)
+	assert.Len(firstDay, 2)
+
+	limited, err := log.Query(Filter{Limit: 1})
+	assert.NoError(err)
+	assert.Len(limited, 1)
+}
+
+func Test_Log_retention(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	log, err := NewLog(conf.AuditConf{Directory: dir, RetentionDays: 7})
+	assert.NoError(err)
+
+	old := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	log.Add(Entry{Time: old, Kind: KindLogin, User: "alice", Result: ResultOk})
+	log.Add(Entry{Time: old.AddDate(0, 0, 10), Kind: KindLogin, User: "bob", Result: ResultOk})
+
+	_, err = os.Stat(filepath.Join(dir, "audit-2019-06-03.jsonl"))
+	assert.True(os.IsNotExist(err))
+	entries, err := log.Query(Filter{})
+	assert.NoError(err)
+	assert.Len(entries, 1)
+}
+
+func Test_Log_disabled(t *testing.T) {
+	log, err := NewLog(conf.AuditConf{})
+	assert.NoError(t, err)
+	assert.Nil(t, log)
+
+	log.Add(Entry{Kind: KindLogin})
+	entries, err := log.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -18,8 +18,22 @@ type TomlConfig struct {
 	Logging    LoggingConf
 	Server     ServerConf
 	Mqtt       MqttConf
+	Auth       AuthConf
 	AuthLocal  AuthLocal
 	AuthOnline AuthOnline
+	AuthLdap   AuthLdap
+	Oidc       OidcConf
+	// if empty, every member may open every door
+	Permissions PermissionsConf
+	Audit       AuditConf
+	Admin       AdminConf
+	Totp        TotpConf
+	Webauthn    WebauthnConf
+	// the [[door]] sections, see DoorList
+	Doors  []DoorConf `toml:"door"`
+	Status StatusConf
+	// the cooldowns and quotas of the door buzzer
+	BuzzLimit BuzzLimitConf
 }
 
 type LoggingConf struct {
@@ -34,6 +48,17 @@ type ServerConf struct {
 	CertKeyFile string
 	CertFile    string
 	KeysFile    string
+	// the server side sessions, defaults to "sessions.json" next to the KeysFile
+	SessionsFile string
+	// the API tokens, defaults to "tokens.json" next to the KeysFile
+	TokensFile string
+	// failed logins per client ip or login name are counted within this time window
+	LoginFailWindowMinutes int
+	// after this many failed logins (within the window) the client ip or login is banned
+	LoginMaxFailures int
+	LoginBanMinutes  int
+	// the users of the sessions are checked against the auth backend after this many minutes, -1 disables the check
+	MembershipCheckMinutes int
 }
 
 type MqttConf struct {
@@ -41,11 +66,40 @@ type MqttConf struct {
 	Username string
 	Password string
 	// if empty, the system certificates are used
-	CertFile                  string
-	StatusTopic               string
+	CertFile    string
+	StatusTopic string
+	// deprecated, only used without [[door]] sections
 	MainDoorBuzzerTopic       string
 	GlassDoorBuzzerTopic      string
 	DoorDownstairsBuzzerTopic string
+	// optional, sesam sends a retained "online" here, the last will is a retained "offline"
+	PresenceTopic string
+	// optional, sesam sends a json event for every opened door here
+	EventTopic string
+}
+
+// BuzzLimitConf limits how often a door can be opened. The cooldowns are never shorter than the buzz duration of the
+// door.
+type BuzzLimitConf struct {
+	// a user can't open the same door again within this time
+	UserCooldownSeconds int
+	// nobody can open the door again within this time
+	DoorCooldownSeconds int
+	// how often a user can open the doors within an hour, 0 = unlimited
+	MaxPerHour int
+}
+
+// StatusConf maps the space states to the allowed actions
+type StatusConf struct {
+	// action -> the states that allow it, e.g. buzz = ["open", "open+", "member"]
+	Actions map[string][]string
+}
+
+type AuthConf struct {
+	// one of "online" (default), "local", "ldap" or "chain"
+	Backend string
+	// the backends for "chain", in the order they are asked
+	Chain []string
 }
 
 type AuthLocal struct {
@@ -56,4 +110,113 @@ type AuthLocal struct {
 type AuthOnline struct {
 	WikiBaseUrl string
 	AuthToken   string
+	// if > 0, members who logged in successfully can still login for this many days while the wiki is down
+	OfflineGraceDays int
+	// if empty, the cache is stored next to the server keys file
+	OfflineCacheFile string
+}
+
+type AuthLdap struct {
+	// e.g. ldaps://ldap.example.org or ldap://ldap.example.org
+	Url      string
+	StartTls bool
+	// service account for the searches, if empty the searches are done anonymously
+	BindDn       string
+	BindPassword string
+	// where to search the users
+	BaseDn string
+	// default: (objectClass=inetOrgPerson)
+	UserFilter string
+	// default: uid
+	UidAttribute string
+	// default: mail
+	MailAttribute string
+	// the member group, e.g. cn=members,ou=groups,dc=example,dc=org
+	GroupDn string
+	// the attribute of the group with the user dn, default: member
+	GroupMemberAttribute string
+}
+
+// OidcConf enables the "Login with SSO" button, if the IssuerUrl is set.
+type OidcConf struct {
+	IssuerUrl    string
+	ClientId     string
+	ClientSecret string
+	// must point to /login/oidc/callback
+	RedirectUrl string
+	// default: openid, profile, email
+	Scopes []string
+	// the claim with the user name, default: preferred_username
+	UserNameClaim string
+	// the claim with the groups of the user, default: groups
+	GroupsClaim string
+	// only members of this group can login
+	RequiredGroup string
+	// an SSO session ends after this time, the next login at the provider checks the group again. Default: 60
+	SessionMinutes int
+}
+
+type PermissionsConf struct {
+	// the timezone of the schedules, e.g. Europe/Berlin. Default: the local timezone
+	Timezone      string
+	Groups        []GroupConf
+	Grants        []GrantConf
+	DoorSchedules []DoorScheduleConf
+}
+
+type GroupConf struct {
+	Name string
+	// optional wiki group page, the same format as AuthLocal.GroupPageFile
+	GroupPageFile string
+	// optional, additional members
+	Users []string
+}
+
+// GrantConf allows the users and the members of the groups to open the doors
+type GrantConf struct {
+	Users  []string
+	Groups []string
+	Doors  []string
+	// optional time windows like "Mon-Fri 08:00-18:00", the grant is only valid within the windows
+	Schedule []string
+}
+
+// DoorScheduleConf limits a door for everybody to the time windows
+type DoorScheduleConf struct {
+	Door     string
+	Schedule []string
+}
+
+type AuditConf struct {
+	// the directory for the audit files, the log is disabled if empty
+	Directory string
+	// the files are removed after this many days, 0 = keep forever
+	RetentionDays int
+}
+
+// AdminConf lists who can see the admin pages
+type AdminConf struct {
+	Users []string
+	// group names of the [Permissions] section
+	Groups []string
+}
+
+// TotpConf configures the second factor. Every user can enroll, members of the RequiredGroups must.
+type TotpConf struct {
+	// the enrolled secrets, defaults to "totp.json" next to the KeysFile
+	SecretsFile string
+	// shown in the authenticator app, defaults to "Sesam"
+	Issuer string
+	// group names of the [Permissions] section
+	RequiredGroups []string
+}
+
+// WebauthnConf enables the passkey login, if RpId is set
+type WebauthnConf struct {
+	// the domain of sesam, e.g. "sesam.example.org"
+	RpId string
+	// the urls of sesam, e.g. "https://sesam.example.org"
+	RpOrigins []string
+	// the passkeys, defaults to "passkeys.json" next to the KeysFile
+	CredentialsFile string
 }
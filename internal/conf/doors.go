@@ -0,0 +1,121 @@
+package conf
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// the amount of ms the door will buzz, if the door doesn't set a duration
+const DEFAULT_BUZZ_DURATION_MS = 4004
+
+// how long to wait for the ack of the door controller, if the door doesn't set a timeout
+const DEFAULT_ACK_TIMEOUT_MS = 5000
+
+// a door controller is offline without a heartbeat for this time, if the door doesn't set a timeout
+const DEFAULT_HEARTBEAT_TIMEOUT_SECONDS = 90
+
+// the last will of a door controller, if the door doesn't set one
+const DEFAULT_OFFLINE_PAYLOAD = "offline"
+
+// DoorConf is a door with a buzzer. The doors are shown in the order of the config, unless Order is set.
+type DoorConf struct {
+	// used in the urls, the permissions and the audit log, e.g. "outer"
+	Id string
+	// the text of the button
+	Label string
+	// the mqtt topic of the buzzer
+	Topic string
+	// the message sent to the topic, default: the buzz duration in ms
+	Payload string
+	// default: 4004
+	BuzzDurationMs int
+	// the space status values that allow opening the door, default: the buzz action of the [status] section
+	AllowedStatuses []string
+	// doors with a lower order are shown first, doors with the same order keep the order of the config
+	Order int
+	// optional, the door controller confirms the buzzer on this topic. The buzzer message is json with a correlation
+	// id then: {"id": "...", "durationMs": 4004, "payload": "..."}, the ack must be {"id": "...", "result": "ok"}.
+	AckTopic string
+	// default: 5000
+	AckTimeoutMs int
+	// optional, the door controller sends a heartbeat (any payload) to this topic. The door is shown as offline
+	// without a heartbeat within the timeout.
+	HeartbeatTopic string
+	// default: 90
+	HeartbeatTimeoutSeconds int
+	// the last will of the door controller on the heartbeat topic, default: offline
+	OfflinePayload string
+}
+
+// DoorList returns the configured doors with their defaults, in the order they are shown. Without [[door]] sections,
+// the three doors of the old [mqtt] topic settings are returned.
+func (c TomlConfig) DoorList() ([]DoorConf, error) {
+	doors := c.Doors
+	if len(doors) == 0 {
+		doors = legacyDoors(c.Mqtt)
+	}
+	if len(doors) == 0 {
+		return nil, errors.New("no doors configured")
+	}
+
+	result := make([]DoorConf, len(doors))
+	ids := make(map[string]struct{})
+	for i, door := range doors {
+		if door.Id == "" {
+			return nil, fmt.Errorf("door %d has no id", i+1)
+		}
+		if _, ok := ids[door.Id]; ok {
+			return nil, fmt.Errorf("duplicate door id '%s'", door.Id)
+		}
+		ids[door.Id] = struct{}{}
+		if door.Topic == "" {
+			return nil, fmt.Errorf("door '%s' has no topic", door.Id)
+		}
+		if door.BuzzDurationMs < 0 {
+			return nil, fmt.Errorf("door '%s' has a negative buzz duration", door.Id)
+		}
+		if door.AckTimeoutMs < 0 {
+			return nil, fmt.Errorf("door '%s' has a negative ack timeout", door.Id)
+		}
+		if door.HeartbeatTimeoutSeconds < 0 {
+			return nil, fmt.Errorf("door '%s' has a negative heartbeat timeout", door.Id)
+		}
+
+		if door.Label == "" {
+			door.Label = "Open " + door.Id
+		}
+		if door.BuzzDurationMs == 0 {
+			door.BuzzDurationMs = DEFAULT_BUZZ_DURATION_MS
+		}
+		if door.Payload == "" {
+			door.Payload = strconv.Itoa(door.BuzzDurationMs)
+		}
+		if door.AckTimeoutMs == 0 {
+			door.AckTimeoutMs = DEFAULT_ACK_TIMEOUT_MS
+		}
+		if door.HeartbeatTimeoutSeconds == 0 {
+			door.HeartbeatTimeoutSeconds = DEFAULT_HEARTBEAT_TIMEOUT_SECONDS
+		}
+		if door.OfflinePayload == "" {
+			door.OfflinePayload = DEFAULT_OFFLINE_PAYLOAD
+		}
+		result[i] = door
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Order < result[j].Order })
+	return result, nil
+}
+
+// legacyDoors returns the doors of the old topic settings, if any is set
+func legacyDoors(config MqttConf) []DoorConf {
+	if config.DoorDownstairsBuzzerTopic == "" && config.GlassDoorBuzzerTopic == "" && config.MainDoorBuzzerTopic == "" {
+		return nil
+	}
+	return []DoorConf{
+		{Id: "outer", Label: "Open OUTER door", Topic: config.DoorDownstairsBuzzerTopic},
+		{Id: "innerGlass", Label: "Open INNER Glass door", Topic: config.GlassDoorBuzzerTopic},
+		{Id: "innerMetal", Label: "Open INNER Metal door", Topic: config.MainDoorBuzzerTopic},
+	}
+}
@@ -0,0 +1,99 @@
+package conf
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DoorList_config(t *testing.T) {
+	assert := assert.New(t)
+	var config TomlConfig
+	_, err := toml.Decode(`
+[[door]]
+id = "front"
+label = "Open the front door"
+topic = "/doors/front/buzzer"
+order = 2
+
+[[door]]
+id = "garage"
+topic = "/doors/garage/buzzer"
+payload = "open"
+buzzDurationMs = 1000
+ackTopic = "/doors/garage/ack"
+ackTimeoutMs = 2000
+heartbeatTopic = "/doors/garage/alive"
+heartbeatTimeoutSeconds = 30
+offlinePayload = "dead"
+allowedStatuses = ["open", "open+", "member", "closed"]
+order = 1
+
+[[door]]
+id = "back"
+topic = "/doors/back/buzzer"
+buzzDurationMs = 2000
+order = 2
+`, &config)
+	assert.NoError(err)
+
+	doors, err := config.DoorList()
+	assert.NoError(err)
+	assert.Len(doors, 3)
+
+	assert.Equal("garage", doors[0].Id)
+	assert.Equal("Open garage", doors[0].Label)
+	assert.Equal("open", doors[0].Payload)
+	assert.Equal("/doors/garage/ack", doors[0].AckTopic)
+	assert.Equal(2000, doors[0].AckTimeoutMs)
+	assert.Equal("/doors/garage/alive", doors[0].HeartbeatTopic)
+	assert.Equal(30, doors[0].HeartbeatTimeoutSeconds)
+	assert.Equal("dead", doors[0].OfflinePayload)
+	assert.Equal([]string{"open", "open+", "member", "closed"}, doors[0].AllowedStatuses)
+
+	// the same order keeps the order of the config
+	assert.Equal("front", doors[1].Id)
+	assert.Equal("Open the front door", doors[1].Label)
+	assert.Equal("4004", doors[1].Payload)
+	assert.Equal("", doors[1].AckTopic)
+	assert.Equal(5000, doors[1].AckTimeoutMs)
+	assert.Equal(90, doors[1].HeartbeatTimeoutSeconds)
+	assert.Equal("offline", doors[1].OfflinePayload)
+	assert.Empty(doors[1].AllowedStatuses)
+	assert.Equal("back", doors[2].Id)
+	assert.Equal("2000", doors[2].Payload)
+}
+
+func Test_DoorList_legacy(t *testing.T) {
+	assert := assert.New(t)
+	config := TomlConfig{Mqtt: MqttConf{
+		MainDoorBuzzerTopic:       "main",
+		GlassDoorBuzzerTopic:      "glass",
+		DoorDownstairsBuzzerTopic: "downstairs",
+	}}
+
+	doors, err := config.DoorList()
+	assert.NoError(err)
+	assert.Len(doors, 3)
+	assert.Equal("outer", doors[0].Id)
+	assert.Equal("downstairs", doors[0].Topic)
+	assert.Equal("innerMetal", doors[2].Id)
+	assert.Equal("main", doors[2].Topic)
+}
+
+func Test_DoorList_invalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := TomlConfig{}.DoorList()
+	assert.EqualError(err, "no doors configured")
+
+	_, err = TomlConfig{Doors: []DoorConf{{Id: "a", Topic: "a"}, {Id: "a", Topic: "b"}}}.DoorList()
+	assert.EqualError(err, "duplicate door id 'a'")
+
+	_, err = TomlConfig{Doors: []DoorConf{{Id: "a"}}}.DoorList()
+	assert.EqualError(err, "door 'a' has no topic")
+
+	_, err = TomlConfig{Doors: []DoorConf{{Topic: "a"}}}.DoorList()
+	assert.EqualError(err, "door 1 has no id")
+}
@@ -2,9 +2,13 @@ package conf
 
 import (
 	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
 	"math/rand"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -16,7 +20,63 @@ func Test_saveAndLoad(t *testing.T) {
 	tmpFile := TempFileName("keys_test", ".tmp")
 	keys_created := createAndSaveNewKeys(tmpFile)
 	keys_loaded := readKeysFromFile(tmpFile)
-	assert.Equal(keys_created, keys_loaded)
+	assert.Equal(keys_created.Current().SessionAuthKey, keys_loaded.Current().SessionAuthKey)
+	assert.Equal(keys_created.Current().SessionEncryptionKey, keys_loaded.Current().SessionEncryptionKey)
+	assert.Equal(keys_created.Current().CsrfKey, keys_loaded.Current().CsrfKey)
+}
+
+func Test_legacyFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpFile := TempFileName("keys_test", ".tmp")
+	defer os.Remove(tmpFile)
+	authKey := GenerateRandomBytes(32)
+	encryptionKey := GenerateRandomBytes(32)
+	csrfKey := GenerateRandomString(32)
+	content := append(append(append([]byte{}, authKey...), encryptionKey...), csrfKey...)
+	assert.NoError(ioutil.WriteFile(tmpFile, content, 0600))
+
+	keys := readKeysFromFile(tmpFile)
+	assert.Len(keys.Generations, 1)
+	assert.Equal(authKey, keys.Current().SessionAuthKey)
+	assert.Equal(encryptionKey, keys.Current().SessionEncryptionKey)
+	assert.Equal(csrfKey, keys.Current().CsrfKey)
+
+	// converted to the new format
+	converted, err := ioutil.ReadFile(tmpFile)
+	assert.NoError(err)
+	assert.True(strings.HasPrefix(string(converted), "sesam-keys\nversion 2\nsha256 "))
+	assert.Equal(keys.Current().CsrfKey, readKeysFromFile(tmpFile).Current().CsrfKey)
+}
+
+func Test_rotateKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	tmpFile := TempFileName("keys_test", ".tmp")
+	defer os.Remove(tmpFile)
+	first := createAndSaveNewKeys(tmpFile)
+
+	rotated, err := RotateKeys(tmpFile, time.Hour)
+	assert.NoError(err)
+	assert.Len(rotated.Generations, 2)
+	assert.NotEqual(first.Current().SessionAuthKey, rotated.Current().SessionAuthKey)
+	assert.Equal(first.Current().SessionAuthKey, rotated.Generations[1].SessionAuthKey)
+
+	pairs := readKeysFromFile(tmpFile).SessionKeyPairs()
+	assert.Len(pairs, 4)
+	// the newest first
+	assert.Equal(rotated.Current().SessionAuthKey, pairs[0])
+	assert.Equal(first.Current().SessionEncryptionKey, pairs[3])
+
+	// without a keep time, the old generations are removed
+	rotated, err = RotateKeys(tmpFile, -time.Second)
+	assert.NoError(err)
+	assert.Len(rotated.Generations, 1)
+}
+
+func Test_rotateKeys_missingFile(t *testing.T) {
+	_, err := RotateKeys(TempFileName("keys_test", ".tmp"), time.Hour)
+	assert.Error(t, err)
 }
 
 // TempFileName generates a temporary filename for use in testing or whatever
@@ -25,3 +85,57 @@ func TempFileName(prefix, suffix string) string {
 	rand.Read(randBytes)
 	return filepath.Join(os.TempDir(), prefix+hex.EncodeToString(randBytes)+suffix)
 }
+
+func Test_jsonFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := Keys{Generations: []KeyGeneration{newKeyGeneration(time.Now()), newKeyGeneration(time.Now())}}
+	payload, err := json.Marshal(keys)
+	assert.NoError(err)
+
+	loaded, version, err := decodeKeyFile(payload, time.Now())
+	assert.NoError(err)
+	assert.Equal(jsonKeyFileVersion, version)
+	assert.Equal(keys.SessionKeyPairs(), loaded.SessionKeyPairs())
+}
+
+func Test_damagedFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := Keys{Generations: []KeyGeneration{newKeyGeneration(time.Now())}}
+	content, err := encodeKeyFile(&keys)
+	assert.NoError(err)
+	_, version, err := decodeKeyFile(content, time.Now())
+	assert.NoError(err)
+	assert.Equal(keyFileVersion, version)
+
+	// truncated
+	_, _, err = decodeKeyFile(content[:len(content)-20], time.Now())
+	assert.Error(err)
+	_, _, err = decodeKeyFile(content[:15], time.Now())
+	assert.Error(err)
+
+	// a changed key
+	damaged := []byte(strings.Replace(string(content), keys.Current().CsrfKey, GenerateRandomString(32), 1))
+	_, _, err = decodeKeyFile(damaged, time.Now())
+	assert.EqualError(err, "checksum mismatch, the file is damaged")
+
+	// unknown version
+	_, _, err = decodeKeyFile([]byte(strings.Replace(string(content), "version 2", "version 3", 1)), time.Now())
+	assert.EqualError(err, "unsupported version 3")
+
+	// a truncated legacy file
+	legacy := append(append(GenerateRandomBytes(32), GenerateRandomBytes(32)...), GenerateRandomString(32)...)
+	_, _, err = decodeKeyFile(legacy[:len(legacy)-1], time.Now())
+	assert.Error(err)
+
+	// valid json with a short key
+	keys.Generations[0].SessionAuthKey = keys.Generations[0].SessionAuthKey[:16]
+	payload, err := json.Marshal(keys)
+	assert.NoError(err)
+	_, _, err = decodeKeyFile(payload, time.Now())
+	assert.Error(err)
+
+	_, _, err = decodeKeyFile([]byte{}, time.Now())
+	assert.Error(err)
+}
@@ -0,0 +1,149 @@
+package conf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The key file starts with a header, followed by the keys as json:
+//
+//	sesam-keys
+//	version 2
+//	sha256 <hex checksum of the json>
+//	{"Generations": [...]}
+const keyFileMagic = "sesam-keys"
+const keyFileVersion = 2
+
+// the keys of the first version were written as raw bytes, without any structure: the auth key, the encryption key
+// and the base64 encoded csrf key
+const legacyKeyLength = 32
+
+var legacyCsrfKeyLength = base64.URLEncoding.EncodedLen(32)
+
+// the json format of the key rotation, without a header
+const jsonKeyFileVersion = 1
+
+// encodeKeyFile returns the content of the key file in the current version
+func encodeKeyFile(keys *Keys) ([]byte, error) {
+	payload, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	checksum := sha256.Sum256(payload)
+
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "%s\nversion %d\nsha256 %s\n", keyFileMagic, keyFileVersion, hex.EncodeToString(checksum[:]))
+	buffer.Write(payload)
+	buffer.WriteString("\n")
+	return buffer.Bytes(), nil
+}
+
+// decodeKeyFile reads every known version of the key file. The returned version is lower than keyFileVersion, if
+// the file should be converted. created is used for the legacy format, that has no creation time.
+func decodeKeyFile(content []byte, created time.Time) (keys *Keys, version int, err error) {
+	switch {
+	case bytes.HasPrefix(content, []byte(keyFileMagic+"\n")):
+		keys, version, err = decodeVersionedKeyFile(content)
+	case bytes.HasPrefix(content, []byte("{")):
+		keys, version, err = decodeJsonKeys(content), jsonKeyFileVersion, nil
+		if keys == nil {
+			err = errors.New("invalid json")
+		}
+	default:
+		keys, version, err = decodeLegacyKeys(content, created), 0, nil
+		if keys == nil {
+			err = fmt.Errorf("unknown format or damaged legacy key file (%d bytes)", len(content))
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := keys.validate(); err != nil {
+		return nil, 0, err
+	}
+	return keys, version, nil
+}
+
+func decodeVersionedKeyFile(content []byte) (*Keys, int, error) {
+	lines := bytes.SplitN(content, []byte("\n"), 4)
+	if len(lines) < 4 {
+		return nil, 0, errors.New("incomplete header")
+	}
+
+	versionLine := string(lines[1])
+	if !bytes.HasPrefix(lines[1], []byte("version ")) {
+		return nil, 0, fmt.Errorf("invalid version line '%s'", versionLine)
+	}
+	version, err := strconv.Atoi(versionLine[len("version "):])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid version line '%s'", versionLine)
+	}
+	if version != keyFileVersion {
+		return nil, 0, fmt.Errorf("unsupported version %d", version)
+	}
+
+	checksumLine := string(lines[2])
+	if !bytes.HasPrefix(lines[2], []byte("sha256 ")) {
+		return nil, 0, fmt.Errorf("invalid checksum line '%s'", checksumLine)
+	}
+	payload := bytes.TrimSuffix(lines[3], []byte("\n"))
+	checksum := sha256.Sum256(payload)
+	if checksumLine[len("sha256 "):] != hex.EncodeToString(checksum[:]) {
+		return nil, 0, errors.New("checksum mismatch, the file is damaged")
+	}
+
+	keys := decodeJsonKeys(payload)
+	if keys == nil {
+		return nil, 0, errors.New("invalid json")
+	}
+	return keys, version, nil
+}
+
+// decodeJsonKeys returns nil for invalid json
+func decodeJsonKeys(payload []byte) *Keys {
+	var keys Keys
+	if err := json.Unmarshal(payload, &keys); err != nil {
+		return nil
+	}
+	return &keys
+}
+
+// decodeLegacyKeys returns nil if the content doesn't have the exact size of the legacy format
+func decodeLegacyKeys(content []byte, created time.Time) *Keys {
+	if len(content) != 2*legacyKeyLength+legacyCsrfKeyLength {
+		return nil
+	}
+
+	generation := KeyGeneration{
+		Created:              created,
+		SessionAuthKey:       content[:legacyKeyLength],
+		SessionEncryptionKey: content[legacyKeyLength : 2*legacyKeyLength],
+		CsrfKey:              string(content[2*legacyKeyLength:]),
+	}
+	return &Keys{Generations: []KeyGeneration{generation}}
+}
+
+// validate checks that every generation has complete keys
+func (k *Keys) validate() error {
+	if len(k.Generations) == 0 {
+		return errors.New("the key file contains no keys")
+	}
+	for i, generation := range k.Generations {
+		if len(generation.SessionAuthKey) != 32 || len(generation.SessionEncryptionKey) != 32 {
+			return fmt.Errorf("the session keys of generation %d have an invalid length", i)
+		}
+		csrfKey, err := base64.URLEncoding.DecodeString(generation.CsrfKey)
+		if err != nil || len(csrfKey) != 32 {
+			return fmt.Errorf("the csrf key of generation %d is invalid", i)
+		}
+	}
+	return nil
+}
@@ -3,9 +3,11 @@ package conf
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"github.com/ktt-ol/sesam/internal/jsonfile"
 	"github.com/sirupsen/logrus"
-	"io"
+	"io/ioutil"
 	"os"
+	"time"
 )
 
 var logger = logrus.WithField("where", "keys")
@@ -20,52 +22,89 @@ func GetKeys(keyStoreFile string) *Keys {
 	return readKeysFromFile(keyStoreFile)
 }
 
-func createAndSaveNewKeys(keyStoreFile string) *Keys {
-	file, err := os.OpenFile(keyStoreFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
-	defer file.Close()
+// RotateKeys adds a new key generation to the key store. New sessions use the new keys, the older generations are
+// kept for keepOldFor after they were replaced, so existing sessions stay valid.
+func RotateKeys(keyStoreFile string, keepOldFor time.Duration) (*Keys, error) {
+	keys, err := loadKeys(keyStoreFile)
 	if err != nil {
-		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't open file (for exclusive write).")
+		return nil, err
 	}
 
-	keys := Keys{
-		SessionAuthKey:       GenerateRandomBytes(32),
-		SessionEncryptionKey: GenerateRandomBytes(32),
-		CsrfKey:              GenerateRandomString(32),
+	now := time.Now()
+	keys.Generations = append([]KeyGeneration{newKeyGeneration(now)}, keys.Generations...)
+	for i := 1; i < len(keys.Generations); i++ {
+		// generation i was replaced when generation i-1 was created
+		if keys.Generations[i-1].Created.Add(keepOldFor).Before(now) {
+			keys.Generations = keys.Generations[:i]
+			break
+		}
+	}
+
+	if err := saveKeys(keyStoreFile, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func createAndSaveNewKeys(keyStoreFile string) *Keys {
+	keys := Keys{Generations: []KeyGeneration{newKeyGeneration(time.Now())}}
+	if err := saveKeys(keyStoreFile, &keys); err != nil {
+		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't save the keys.")
 	}
-	file.Write(keys.SessionAuthKey)
-	file.Write(keys.SessionEncryptionKey)
-	file.WriteString(keys.CsrfKey)
 
 	return &keys
 }
 
 func readKeysFromFile(keyStoreFile string) *Keys {
-	file, err := os.Open(keyStoreFile)
-	defer file.Close()
+	keys, err := loadKeys(keyStoreFile)
 	if err != nil {
-		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't open file (for read).")
+		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).
+			Fatal("Can't read the keys. Restore a backup or delete the file, the latter logs out everyone.")
 	}
 
-	keys := Keys{
-		SessionAuthKey:       make([]byte, 32),
-		SessionEncryptionKey: make([]byte, 32),
-		CsrfKey:              "",
+	return keys
+}
+
+// loadKeys reads the key store. A key store of an older version is converted and saved again.
+func loadKeys(keyStoreFile string) (*Keys, error) {
+	content, err := ioutil.ReadFile(keyStoreFile)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := file.Read(keys.SessionAuthKey); err != nil {
-		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't read 'SessionAuthKey'.")
+	info, err := os.Stat(keyStoreFile)
+	if err != nil {
+		return nil, err
 	}
-	if _, err := file.Read(keys.SessionEncryptionKey); err != nil {
-		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't read 'SessionEncryptionKey'.")
+
+	keys, version, err := decodeKeyFile(content, info.ModTime())
+	if err != nil {
+		return nil, err
 	}
-	// the base64 encoding of 32 bytes must be less than 64 bytes
-	buffer := make([]byte, 64)
-	read, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		logger.WithError(err).WithField("keyStoreFile", keyStoreFile).Fatal("Can't read 'SessionEncryptionKey'.")
+	if version < keyFileVersion {
+		logger.WithField("keyStoreFile", keyStoreFile).WithField("version", version).Info("Converting the key store to the current format.")
+		if err := saveKeys(keyStoreFile, keys); err != nil {
+			return nil, err
+		}
 	}
-	keys.CsrfKey = string(buffer[:read])
 
-	return &keys
+	return keys, nil
+}
+
+func saveKeys(keyStoreFile string, keys *Keys) error {
+	content, err := encodeKeyFile(keys)
+	if err != nil {
+		return err
+	}
+	return jsonfile.WriteFile(keyStoreFile, content)
+}
+
+func newKeyGeneration(created time.Time) KeyGeneration {
+	return KeyGeneration{
+		Created:              created,
+		SessionAuthKey:       GenerateRandomBytes(32),
+		SessionEncryptionKey: GenerateRandomBytes(32),
+		CsrfKey:              GenerateRandomString(32),
+	}
 }
 
 // https://elithrar.github.io/article/generating-secure-random-numbers-crypto-rand/
@@ -92,8 +131,30 @@ func GenerateRandomString(s int) string {
 	return base64.URLEncoding.EncodeToString(b)
 }
 
+// Keys contains all key generations, the newest first
 type Keys struct {
+	Generations []KeyGeneration
+}
+
+// KeyGeneration is one set of keys, created with the key store or a rotation
+type KeyGeneration struct {
+	Created              time.Time
 	SessionAuthKey       []byte
 	SessionEncryptionKey []byte
 	CsrfKey              string
 }
+
+// Current returns the newest generation, it's used for new sessions
+func (k *Keys) Current() KeyGeneration {
+	return k.Generations[0]
+}
+
+// SessionKeyPairs returns the auth and encryption key of every generation, the newest first. This is the order
+// securecookie.CodecsFromPairs expects.
+func (k *Keys) SessionKeyPairs() [][]byte {
+	var pairs [][]byte
+	for _, generation := range k.Generations {
+		pairs = append(pairs, generation.SessionAuthKey, generation.SessionEncryptionKey)
+	}
+	return pairs
+}
@@ -0,0 +1,52 @@
+// Package jsonfile loads and saves the small data stores of sesam as json files.
+package jsonfile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Load reads the json file into data. A missing file is not an error, data stays untouched then.
+func Load(file string, data interface{}) error {
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(content, data)
+}
+
+// Save writes data as json to the file, like WriteFile.
+func Save(file string, data interface{}) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return WriteFile(file, content)
+}
+
+// WriteFile writes the content to a temporary file first, so a crash never leaves a half written file behind. Only
+// the owner can read the file.
+func WriteFile(file string, content []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(content); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), file)
+}
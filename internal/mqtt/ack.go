@@ -0,0 +1,92 @@
+package mqtt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// BuzzResult is the outcome of SendDoorBuzzer
+type BuzzResult string
+
+const (
+	// the buzzer message was sent, and confirmed if the door has an ack topic
+	BuzzOk BuzzResult = "buzzed"
+	// the space status doesn't allow the door
+	BuzzNotAllowed BuzzResult = "not allowed"
+	// no connection to the broker or the door controller
+	BuzzOffline BuzzResult = "controller offline"
+	// the door controller didn't confirm the message in time
+	BuzzTimeout BuzzResult = "timeout"
+	// the door controller answered with an error
+	BuzzRejected BuzzResult = "rejected"
+)
+
+const ackIdLength = 8
+
+// the ack result of the door controller if it opened the door
+const ackResultOk = "ok"
+
+// buzzRequest is the payload for doors with an ack topic
+type buzzRequest struct {
+	// the correlation id, the ack must contain it
+	Id         string `json:"id"`
+	DurationMs int    `json:"durationMs"`
+	// the payload of the door config
+	Payload string `json:"payload"`
+}
+
+// buzzAck is the answer of the door controller on the ack topic
+type buzzAck struct {
+	Id string `json:"id"`
+	// "ok" or an error like "jammed"
+	Result  string `json:"result"`
+	Message string `json:"message"`
+}
+
+// ackWaiter connects the acks of the door controllers to the waiting buzzer requests
+type ackWaiter struct {
+	mux     sync.Mutex
+	pending map[string]chan buzzAck
+}
+
+func newAckWaiter() *ackWaiter {
+	return &ackWaiter{pending: make(map[string]chan buzzAck)}
+}
+
+// register returns a new correlation id and the channel of its ack. Call done when not waiting anymore.
+func (a *ackWaiter) register() (id string, ack <-chan buzzAck, done func()) {
+	id = hex.EncodeToString(conf.GenerateRandomBytes(ackIdLength))
+	channel := make(chan buzzAck, 1)
+
+	a.mux.Lock()
+	a.pending[id] = channel
+	a.mux.Unlock()
+
+	return id, channel, func() {
+		a.mux.Lock()
+		delete(a.pending, id)
+		a.mux.Unlock()
+	}
+}
+
+// deliver passes the ack payload to the waiting request. It returns false for unknown or late acks.
+func (a *ackWaiter) deliver(payload []byte) bool {
+	var ack buzzAck
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		mqttLogger.WithError(err).WithField("payload", string(payload)).Warn("Invalid buzzer ack.")
+		return false
+	}
+
+	a.mux.Lock()
+	channel, ok := a.pending[ack.Id]
+	delete(a.pending, ack.Id)
+	a.mux.Unlock()
+	if !ok {
+		return false
+	}
+	channel <- ack
+	return true
+}
@@ -0,0 +1,41 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ackWaiter(t *testing.T) {
+	assert := assert.New(t)
+	waiter := newAckWaiter()
+
+	firstId, firstAck, firstDone := waiter.register()
+	secondId, secondAck, secondDone := waiter.register()
+	defer firstDone()
+	defer secondDone()
+	assert.Len(firstId, 2*ackIdLength)
+	assert.NotEqual(firstId, secondId)
+
+	assert.True(waiter.deliver([]byte(`{"id": "` + secondId + `", "result": "jammed", "message": "the bolt is stuck"}`)))
+	ack := <-secondAck
+	assert.Equal("jammed", ack.Result)
+	assert.Equal("the bolt is stuck", ack.Message)
+	// only once
+	assert.False(waiter.deliver([]byte(`{"id": "` + secondId + `", "result": "ok"}`)))
+
+	assert.False(waiter.deliver([]byte(`{"id": "unknown", "result": "ok"}`)))
+	assert.False(waiter.deliver([]byte(`not json`)))
+
+	assert.True(waiter.deliver([]byte(`{"id": "` + firstId + `", "result": "ok"}`)))
+	assert.Equal(ackResultOk, (<-firstAck).Result)
+}
+
+func Test_ackWaiter_late(t *testing.T) {
+	assert := assert.New(t)
+	waiter := newAckWaiter()
+
+	id, _, done := waiter.register()
+	done()
+	assert.False(waiter.deliver([]byte(`{"id": "` + id + `", "result": "ok"}`)))
+}
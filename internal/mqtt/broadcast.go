@@ -0,0 +1,62 @@
+package mqtt
+
+import "sync"
+
+// statusBroadcast keeps the space status and sends every change to the subscribers. A slow subscriber only gets the
+// latest status, the older ones are dropped.
+type statusBroadcast struct {
+	mux         sync.Mutex
+	status      Status
+	subscribers map[chan Status]struct{}
+}
+
+func (b *statusBroadcast) get() Status {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	return b.status
+}
+
+// set sends the status to the subscribers, if the state or who set it changed
+func (b *statusBroadcast) set(status Status) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if status.State == b.status.State && status.SetBy == b.status.SetBy {
+		return
+	}
+	b.status = status
+	for subscriber := range b.subscribers {
+		// replace a status the subscriber didn't read yet
+		select {
+		case <-subscriber:
+		default:
+		}
+		subscriber <- status
+	}
+}
+
+// subscribe returns a channel with the current status and all following changes. The channel is closed by the
+// returned unsubscribe function.
+func (b *statusBroadcast) subscribe() (<-chan Status, func()) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[chan Status]struct{})
+	}
+	subscriber := make(chan Status, 1)
+	subscriber <- b.status
+	b.subscribers[subscriber] = struct{}{}
+
+	unsubscribe := func() {
+		b.mux.Lock()
+		defer b.mux.Unlock()
+
+		if _, ok := b.subscribers[subscriber]; ok {
+			delete(b.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	return subscriber, unsubscribe
+}
@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_statusBroadcast(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	var broadcast statusBroadcast
+	broadcast.set(Status{State: StateOpen, Since: now})
+
+	first, unsubscribeFirst := broadcast.subscribe()
+	second, unsubscribeSecond := broadcast.subscribe()
+	assert.Equal(StateOpen, (<-first).State)
+	assert.Equal(StateOpen, (<-second).State)
+
+	broadcast.set(Status{State: StateMember})
+	assert.Equal(StateMember, (<-first).State)
+
+	// the second subscriber didn't read, it only gets the latest status
+	broadcast.set(Status{State: StateClosed})
+	assert.Equal(StateClosed, (<-first).State)
+	assert.Equal(StateClosed, (<-second).State)
+
+	// no change, no message
+	broadcast.set(Status{State: StateClosed, Since: now.Add(time.Minute)})
+	select {
+	case status := <-first:
+		t.Errorf("unexpected status %v", status)
+	default:
+	}
+	// somebody else set it
+	broadcast.set(Status{State: StateClosed, SetBy: "alice"})
+	assert.Equal("alice", (<-first).SetBy)
+
+	unsubscribeFirst()
+	_, ok := <-first
+	assert.False(ok)
+	// unsubscribing twice is fine
+	unsubscribeFirst()
+
+	broadcast.set(Status{State: StateOpen})
+	assert.Equal(StateOpen, (<-second).State)
+	assert.Equal(StateOpen, broadcast.get().State)
+	unsubscribeSecond()
+}
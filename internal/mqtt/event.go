@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// the retained payloads of the presence topic, "offline" is the last will
+const (
+	presenceOnline  = "online"
+	presenceOffline = "offline"
+)
+
+const eventBuzz = "buzz"
+
+// event is published on the event topic, for other services of the space
+type event struct {
+	Event string `json:"event"`
+	Door  string `json:"door"`
+	Label string `json:"label"`
+	// unix time
+	Time int64 `json:"time"`
+	// only if the user agreed to share the name
+	User string `json:"user,omitempty"`
+}
+
+func buzzEventPayload(door conf.DoorConf, userName string, at time.Time) ([]byte, error) {
+	return json.Marshal(event{Event: eventBuzz, Door: door.Id, Label: door.Label, Time: at.Unix(), User: userName})
+}
+
+// PublishesEvents returns true if an event topic is configured
+func (h *MqttHandler) PublishesEvents() bool {
+	return h.conf.EventTopic != ""
+}
+
+// PublishBuzzEvent announces an opened door on the event topic. The user name is left out if empty. It doesn't wait
+// for the broker.
+func (h *MqttHandler) PublishBuzzEvent(door conf.DoorConf, userName string) {
+	if !h.PublishesEvents() {
+		return
+	}
+	payload, err := buzzEventPayload(door, userName, time.Now())
+	if err != nil {
+		mqttLogger.WithError(err).Error("Can't create the buzz event.")
+		return
+	}
+	go h.publish(h.conf.EventTopic, string(payload))
+}
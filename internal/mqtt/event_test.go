@@ -0,0 +1,24 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_buzzEventPayload(t *testing.T) {
+	assert := assert.New(t)
+	door := conf.DoorConf{Id: "outer", Label: "Open OUTER door"}
+	at := time.Unix(1559556000, 0)
+
+	payload, err := buzzEventPayload(door, "alice", at)
+	assert.NoError(err)
+	assert.JSONEq(`{"event": "buzz", "door": "outer", "label": "Open OUTER door", "time": 1559556000, "user": "alice"}`, string(payload))
+
+	// without the user
+	payload, err = buzzEventPayload(door, "", at)
+	assert.NoError(err)
+	assert.JSONEq(`{"event": "buzz", "door": "outer", "label": "Open OUTER door", "time": 1559556000}`, string(payload))
+}
@@ -0,0 +1,109 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// DoorState is the liveness of a door controller
+type DoorState struct {
+	// false if the door has no heartbeat topic, it's always online then
+	Monitored bool
+	Online    bool
+	// the last heartbeat, zero if none was received since the start
+	LastSeen time.Time
+}
+
+type livenessEntry struct {
+	topic          string
+	offlinePayload string
+	timeout        time.Duration
+	lastSeen       time.Time
+	// the controller sent its last will
+	offline bool
+}
+
+// doorLiveness tracks the heartbeats of the door controllers. A controller is offline if it sent its last will or
+// no heartbeat within the timeout.
+type doorLiveness struct {
+	mux sync.Mutex
+	// the controllers get the timeout after the start to send their first heartbeat
+	started time.Time
+	// door id -> entry, only doors with a heartbeat topic
+	doors map[string]*livenessEntry
+}
+
+func newDoorLiveness(doors []conf.DoorConf, now time.Time) *doorLiveness {
+	liveness := doorLiveness{started: now, doors: make(map[string]*livenessEntry)}
+	for _, door := range doors {
+		if door.HeartbeatTopic == "" {
+			continue
+		}
+		liveness.doors[door.Id] = &livenessEntry{
+			topic:          door.HeartbeatTopic,
+			offlinePayload: door.OfflinePayload,
+			timeout:        time.Duration(door.HeartbeatTimeoutSeconds) * time.Second,
+		}
+	}
+	return &liveness
+}
+
+// topics returns the heartbeat topics, every topic once
+func (l *doorLiveness) topics() []string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	var topics []string
+	seen := make(map[string]bool)
+	for _, entry := range l.doors {
+		if !seen[entry.topic] {
+			seen[entry.topic] = true
+			topics = append(topics, entry.topic)
+		}
+	}
+	return topics
+}
+
+// heartbeat records a message of the heartbeat topic, for all doors of the topic
+func (l *doorLiveness) heartbeat(topic string, payload []byte, now time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	for doorId, entry := range l.doors {
+		if entry.topic != topic {
+			continue
+		}
+		wasOnline := entry.isOnline(l.started, now)
+		entry.offline = string(payload) == entry.offlinePayload
+		if !entry.offline {
+			entry.lastSeen = now
+		}
+		if isOnline := entry.isOnline(l.started, now); isOnline != wasOnline {
+			mqttLogger.WithField("door", doorId).WithField("online", isOnline).Warn("door controller state changed")
+		}
+	}
+}
+
+func (l *doorLiveness) state(doorId string, now time.Time) DoorState {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	entry, ok := l.doors[doorId]
+	if !ok {
+		return DoorState{Monitored: false, Online: true}
+	}
+	return DoorState{Monitored: true, Online: entry.isOnline(l.started, now), LastSeen: entry.lastSeen}
+}
+
+func (e *livenessEntry) isOnline(started time.Time, now time.Time) bool {
+	if e.offline {
+		return false
+	}
+	last := e.lastSeen
+	if last.IsZero() {
+		last = started
+	}
+	return now.Sub(last) < e.timeout
+}
@@ -0,0 +1,49 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_doorLiveness(t *testing.T) {
+	assert := assert.New(t)
+	start := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	doors := []conf.DoorConf{
+		{Id: "outer", HeartbeatTopic: "/downstairs/alive", HeartbeatTimeoutSeconds: 60, OfflinePayload: "offline"},
+		{Id: "innerGlass", HeartbeatTopic: "/upstairs/alive", HeartbeatTimeoutSeconds: 60, OfflinePayload: "offline"},
+		{Id: "innerMetal", HeartbeatTopic: "/upstairs/alive", HeartbeatTimeoutSeconds: 60, OfflinePayload: "offline"},
+		{Id: "garage"},
+	}
+	liveness := newDoorLiveness(doors, start)
+	assert.ElementsMatch([]string{"/downstairs/alive", "/upstairs/alive"}, liveness.topics())
+
+	// without a heartbeat topic, always online
+	assert.Equal(DoorState{Monitored: false, Online: true}, liveness.state("garage", start.Add(time.Hour)))
+
+	// the controllers get the timeout for the first heartbeat
+	assert.True(liveness.state("outer", start.Add(59*time.Second)).Online)
+	assert.False(liveness.state("outer", start.Add(60*time.Second)).Online)
+
+	now := start.Add(2 * time.Minute)
+	liveness.heartbeat("/downstairs/alive", []byte("online"), now)
+	state := liveness.state("outer", now.Add(30*time.Second))
+	assert.True(state.Monitored)
+	assert.True(state.Online)
+	assert.Equal(now, state.LastSeen)
+	assert.False(liveness.state("outer", now.Add(61*time.Second)).Online)
+
+	// one controller for two doors
+	liveness.heartbeat("/upstairs/alive", []byte("1"), now)
+	assert.True(liveness.state("innerGlass", now).Online)
+	assert.True(liveness.state("innerMetal", now).Online)
+
+	// the last will
+	liveness.heartbeat("/upstairs/alive", []byte("offline"), now.Add(time.Second))
+	assert.False(liveness.state("innerGlass", now.Add(time.Second)).Online)
+	assert.Equal(now, liveness.state("innerGlass", now.Add(time.Second)).LastSeen)
+	liveness.heartbeat("/upstairs/alive", []byte("online"), now.Add(2*time.Second))
+	assert.True(liveness.state("innerMetal", now.Add(2*time.Second)).Online)
+}
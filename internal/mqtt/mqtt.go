@@ -3,10 +3,10 @@ package mqtt
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"io/ioutil"
 	"time"
 
-	"fmt"
 	"github.com/eclipse/paho.mqtt.golang"
 	"github.com/ktt-ol/sesam/internal/conf"
 	"github.com/sirupsen/logrus"
@@ -14,21 +14,17 @@ import (
 
 const CLIENT_ID = "sesam"
 
-// the amount of ms the door will buzz
-const BUZZER_DURATION = 4004
-
 var mqttLogger = logrus.WithField("where", "mqtt")
 
-type Door int8
-
-const DoorOuter = Door(0)
-const DoorInnerGlass = Door(1)
-const DoorInnerMetal = Door(2)
-
 type MqttHandler struct {
 	client mqtt.Client
-	status string
+	status statusBroadcast
+	policy StatusPolicy
 	conf   conf.MqttConf
+	// in the order they are shown
+	doors    []conf.DoorConf
+	acks     *ackWaiter
+	liveness *doorLiveness
 }
 
 type mqttDebugLogger struct {
@@ -50,7 +46,7 @@ func EnableMqttDebugLogging() {
 	mqtt.DEBUG = mqttDebugLogger{mqttLogger, logrus.DebugLevel}
 }
 
-func NewMqttHandler(conf conf.MqttConf) *MqttHandler {
+func NewMqttHandler(conf conf.MqttConf, policy StatusPolicy, doors []conf.DoorConf) *MqttHandler {
 	opts := mqtt.NewClientOptions()
 
 	opts.AddBroker(conf.Url)
@@ -73,8 +69,17 @@ func NewMqttHandler(conf conf.MqttConf) *MqttHandler {
 	opts.SetCleanSession(true)
 	opts.SetKeepAlive(10 * time.Second)
 	opts.SetMaxReconnectInterval(5 * time.Minute)
+	if conf.PresenceTopic != "" {
+		opts.SetWill(conf.PresenceTopic, presenceOffline, 1, true)
+	}
 
-	handler := MqttHandler{conf: conf}
+	handler := MqttHandler{
+		conf:     conf,
+		policy:   policy,
+		doors:    doors,
+		acks:     newAckWaiter(),
+		liveness: newDoorLiveness(doors, time.Now()),
+	}
 	opts.SetOnConnectHandler(handler.onConnect)
 	opts.SetConnectionLostHandler(handler.onConnectionLost)
 
@@ -86,55 +91,156 @@ func NewMqttHandler(conf conf.MqttConf) *MqttHandler {
 	return &handler
 }
 
-func (h *MqttHandler) CurrentStatus() string {
-	return h.status
+func (h *MqttHandler) CurrentStatus() Status {
+	return h.status.get()
 }
 
-func (h *MqttHandler) SendDoorBuzzer(door Door) bool {
-	if h.status != "open" && h.status != "open+" && h.status != "member" {
-		mqttLogger.WithField("status", h.status).Error("door buzzer is not allowed for the current status.")
-		return false
+// SubscribeStatus returns a channel with the current status and all following changes. Call unsubscribe when done.
+func (h *MqttHandler) SubscribeStatus() (statusChanges <-chan Status, unsubscribe func()) {
+	return h.status.subscribe()
+}
+
+// Doors returns the configured doors, in the order they are shown
+func (h *MqttHandler) Doors() []conf.DoorConf {
+	return h.doors
+}
+
+// Connected returns true if the connection to the broker is open
+func (h *MqttHandler) Connected() bool {
+	return h.client.IsConnectionOpen()
+}
+
+// DoorState returns the liveness of the controller of the door
+func (h *MqttHandler) DoorState(doorId string) DoorState {
+	return h.liveness.state(doorId, time.Now())
+}
+
+// Policy returns the mapping of the states to the allowed actions
+func (h *MqttHandler) Policy() StatusPolicy {
+	return h.policy
+}
+
+// IsAllowed returns true if the action is allowed in the current state
+func (h *MqttHandler) IsAllowed(action Action) bool {
+	return h.policy.Allows(action, h.CurrentStatus().State)
+}
+
+// MayBuzz returns true if the door can be opened in the current state
+func (h *MqttHandler) MayBuzz(door conf.DoorConf) bool {
+	return h.policy.MayBuzz(door, h.CurrentStatus().State)
+}
+
+// SendDoorBuzzer opens the door. Doors with an ack topic wait for the confirmation of the door controller.
+func (h *MqttHandler) SendDoorBuzzer(door conf.DoorConf) BuzzResult {
+	doorLogger := mqttLogger.WithField("door", door.Id)
+	if status := h.CurrentStatus(); !h.policy.MayBuzz(door, status.State) {
+		doorLogger.WithField("status", status.State).Error("door buzzer is not allowed for the current status.")
+		return BuzzNotAllowed
+	}
+	if !h.client.IsConnectionOpen() {
+		doorLogger.Error("Can't send the door buzzer, not connected.")
+		return BuzzOffline
+	}
+	if !h.DoorState(door.Id).Online {
+		doorLogger.Error("Can't send the door buzzer, the door controller is offline.")
+		return BuzzOffline
 	}
 
-	var topic string
-	switch door {
-	case DoorOuter:
-		topic = h.conf.DoorDownstairsBuzzerTopic
-		break
-	case DoorInnerGlass:
-		topic = h.conf.GlassDoorBuzzerTopic
-		break
-	case DoorInnerMetal:
-		topic = h.conf.MainDoorBuzzerTopic
-		break
-	}
-	token := h.client.Publish(topic, 0, false, fmt.Sprintf("%d", BUZZER_DURATION))
-	ok := token.WaitTimeout(time.Duration(time.Second * 10))
-	if !ok {
-		mqttLogger.WithError(token.Error()).WithField("topic", topic).Info("Error sending door buzzer.")
-		return false
+	if door.AckTopic == "" {
+		if !h.publish(door.Topic, door.Payload) {
+			return BuzzOffline
+		}
+		return BuzzOk
 	}
 
+	id, ack, done := h.acks.register()
+	defer done()
+	payload, err := json.Marshal(buzzRequest{Id: id, DurationMs: door.BuzzDurationMs, Payload: door.Payload})
+	if err != nil {
+		doorLogger.WithError(err).Error("Can't create the buzzer message.")
+		return BuzzOffline
+	}
+	timeout := time.NewTimer(time.Duration(door.AckTimeoutMs) * time.Millisecond)
+	defer timeout.Stop()
+	if !h.publish(door.Topic, string(payload)) {
+		return BuzzOffline
+	}
+
+	select {
+	case answer := <-ack:
+		if answer.Result != ackResultOk {
+			doorLogger.WithField("result", answer.Result).WithField("message", answer.Message).Warn("The door controller rejected the buzzer.")
+			return BuzzRejected
+		}
+		return BuzzOk
+	case <-timeout.C:
+		doorLogger.WithField("id", id).Warn("The door controller didn't confirm the buzzer.")
+		return BuzzTimeout
+	}
+}
+
+func (h *MqttHandler) publish(topic string, payload string) bool {
+	token := h.client.Publish(topic, 0, false, payload)
+	if !token.WaitTimeout(time.Duration(time.Second*10)) || token.Error() != nil {
+		mqttLogger.WithError(token.Error()).WithField("topic", topic).Info("Error publishing.")
+		return false
+	}
 	return true
 }
 
 func (h *MqttHandler) onConnect(client mqtt.Client) {
 	mqttLogger.Info("connected")
 
+	if h.conf.PresenceTopic != "" {
+		// replaces the retained last will of the previous connection, not waiting within the connect handler
+		client.Publish(h.conf.PresenceTopic, 1, true, presenceOnline)
+	}
+
 	err := subscribe(client, h.conf.StatusTopic,
 		func(client mqtt.Client, message mqtt.Message) {
-			h.status = string(message.Payload())
-			mqttLogger.WithField("status", h.status).Info("got new status")
+			status := ParseStatus(message.Payload(), time.Now())
+			h.status.set(status)
+			entry := mqttLogger.WithField("status", status.State).WithField("setBy", status.SetBy)
+			if status.State.IsKnown() {
+				entry.Info("got new status")
+			} else {
+				entry.Warn("got an unknown status")
+			}
 		})
 	if err != nil {
 		mqttLogger.WithError(err).Fatal("Could not subscribe.")
 	}
+
+	subscribed := make(map[string]bool)
+	for _, door := range h.doors {
+		if door.AckTopic == "" || subscribed[door.AckTopic] {
+			continue
+		}
+		subscribed[door.AckTopic] = true
+		err := subscribe(client, door.AckTopic, func(client mqtt.Client, message mqtt.Message) {
+			if !h.acks.deliver(message.Payload()) {
+				mqttLogger.WithField("topic", message.Topic()).Debug("Ack without a waiting buzzer.")
+			}
+		})
+		if err != nil {
+			mqttLogger.WithError(err).WithField("topic", door.AckTopic).Fatal("Could not subscribe.")
+		}
+	}
+
+	for _, topic := range h.liveness.topics() {
+		err := subscribe(client, topic, func(client mqtt.Client, message mqtt.Message) {
+			h.liveness.heartbeat(message.Topic(), message.Payload(), time.Now())
+		})
+		if err != nil {
+			mqttLogger.WithError(err).WithField("topic", topic).Fatal("Could not subscribe.")
+		}
+	}
 }
 
 func (h *MqttHandler) onConnectionLost(client mqtt.Client, err error) {
 	mqttLogger.WithError(err).Error("Connection lost.")
 	// clearing the status
-	h.status = ""
+	h.status.set(Status{State: StateUnknown, Since: time.Now()})
 }
 
 func subscribe(client mqtt.Client, topic string, cb mqtt.MessageHandler) error {
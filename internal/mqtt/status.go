@@ -0,0 +1,143 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// State is the space status, like the status topic sends it
+type State string
+
+// the known states, other payloads are kept as they are
+const (
+	// no status received yet or the connection to the broker is lost
+	StateUnknown   State = ""
+	StateNone      State = "none"
+	StateClosed    State = "closed"
+	StateKeyholder State = "keyholder"
+	StateMember    State = "member"
+	StateOpen      State = "open"
+	StateOpenPlus  State = "open+"
+)
+
+var knownStates = []State{StateNone, StateClosed, StateKeyholder, StateMember, StateOpen, StateOpenPlus}
+
+// IsKnown returns false for payloads that are not one of the State constants
+func (s State) IsKnown() bool {
+	for _, known := range knownStates {
+		if s == known {
+			return true
+		}
+	}
+	return s == StateUnknown
+}
+
+// Status is the space status with its metadata
+type Status struct {
+	State State
+	// when the status was set, the time of the payload or when sesam received it
+	Since time.Time
+	// who set the status, if the payload contains it
+	SetBy string
+	// the payload as received
+	Raw string
+}
+
+// statusPayload is the optional json format of the status topic
+type statusPayload struct {
+	State string `json:"state"`
+	SetBy string `json:"setBy"`
+	// unix seconds
+	Since int64 `json:"since"`
+}
+
+// ParseStatus reads the payload of the status topic. It's either the plain state, e.g. "open", or json like
+// {"state": "open", "setBy": "alice", "since": 1559556000}.
+func ParseStatus(payload []byte, received time.Time) Status {
+	raw := string(payload)
+	status := Status{State: State(strings.TrimSpace(raw)), Since: received, Raw: raw}
+
+	trimmed := strings.TrimSpace(raw)
+	if !strings.HasPrefix(trimmed, "{") {
+		return status
+	}
+	var parsed statusPayload
+	if err := json.Unmarshal([]byte(trimmed), &parsed); err != nil {
+		mqttLogger.WithError(err).WithField("payload", raw).Warn("Invalid json status.")
+		return status
+	}
+	status.State = State(strings.TrimSpace(parsed.State))
+	status.SetBy = parsed.SetBy
+	if parsed.Since > 0 {
+		status.Since = time.Unix(parsed.Since, 0)
+	}
+	return status
+}
+
+// Action is something the members can do, depending on the space status
+type Action string
+
+const (
+	// open the doors, unless the door has its own allowedStatuses
+	ActionBuzz Action = "buzz"
+)
+
+var knownActions = map[Action][]State{
+	ActionBuzz: {StateOpen, StateOpenPlus, StateMember},
+}
+
+// StatusPolicy maps the states to the allowed actions
+type StatusPolicy struct {
+	allowed map[Action]map[State]bool
+}
+
+// NewStatusPolicy returns the policy of the [status.actions] config, actions without config get the defaults.
+func NewStatusPolicy(config conf.StatusConf) (StatusPolicy, error) {
+	policy := StatusPolicy{allowed: make(map[Action]map[State]bool)}
+	for action, defaultStates := range knownActions {
+		states := make(map[State]bool)
+		for _, state := range defaultStates {
+			states[state] = true
+		}
+		policy.allowed[action] = states
+	}
+
+	for name, configStates := range config.Actions {
+		action := Action(name)
+		if _, ok := knownActions[action]; !ok {
+			return StatusPolicy{}, fmt.Errorf("unknown status action '%s'", name)
+		}
+		states := make(map[State]bool)
+		for _, state := range configStates {
+			if !State(state).IsKnown() {
+				mqttLogger.WithField("state", state).WithField("action", name).Warn("Unknown state in the status actions.")
+			}
+			states[State(state)] = true
+		}
+		policy.allowed[action] = states
+	}
+	return policy, nil
+}
+
+// Allows returns true if the action is allowed in the state
+func (p StatusPolicy) Allows(action Action, state State) bool {
+	return p.allowed[action][state]
+}
+
+// MayBuzz returns true if the door can be opened in the state. The allowedStatuses of the door replace the buzz
+// action.
+func (p StatusPolicy) MayBuzz(door conf.DoorConf, state State) bool {
+	if len(door.AllowedStatuses) == 0 {
+		return p.Allows(ActionBuzz, state)
+	}
+	for _, allowed := range door.AllowedStatuses {
+		if State(allowed) == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseStatus(t *testing.T) {
+	assert := assert.New(t)
+	received := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+
+	status := ParseStatus([]byte("open+"), received)
+	assert.Equal(StateOpenPlus, status.State)
+	assert.True(status.State.IsKnown())
+	assert.Equal(received, status.Since)
+	assert.Equal("", status.SetBy)
+
+	status = ParseStatus([]byte(`{"state": "member", "setBy": "alice", "since": 1559556000}`), received)
+	assert.Equal(StateMember, status.State)
+	assert.Equal("alice", status.SetBy)
+	assert.Equal(time.Unix(1559556000, 0), status.Since)
+	assert.Equal(`{"state": "member", "setBy": "alice", "since": 1559556000}`, status.Raw)
+
+	// without a time, the time of receiving is used
+	status = ParseStatus([]byte(`{"state": "closed"}`), received)
+	assert.Equal(StateClosed, status.State)
+	assert.Equal(received, status.Since)
+
+	status = ParseStatus([]byte("party"), received)
+	assert.Equal(State("party"), status.State)
+	assert.False(status.State.IsKnown())
+
+	status = ParseStatus([]byte("{broken"), received)
+	assert.Equal(State("{broken"), status.State)
+	assert.False(status.State.IsKnown())
+}
+
+func Test_StatusPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	policy, err := NewStatusPolicy(conf.StatusConf{})
+	assert.NoError(err)
+	assert.True(policy.Allows(ActionBuzz, StateOpen))
+	assert.True(policy.Allows(ActionBuzz, StateOpenPlus))
+	assert.True(policy.Allows(ActionBuzz, StateMember))
+	assert.False(policy.Allows(ActionBuzz, StateKeyholder))
+	assert.False(policy.Allows(ActionBuzz, StateClosed))
+	assert.False(policy.Allows(ActionBuzz, StateUnknown))
+
+	policy, err = NewStatusPolicy(conf.StatusConf{Actions: map[string][]string{"buzz": {"open", "keyholder"}}})
+	assert.NoError(err)
+	assert.True(policy.Allows(ActionBuzz, StateKeyholder))
+	assert.False(policy.Allows(ActionBuzz, StateMember))
+
+	_, err = NewStatusPolicy(conf.StatusConf{Actions: map[string][]string{"dance": {"open"}}})
+	assert.EqualError(err, "unknown status action 'dance'")
+}
+
+func Test_StatusPolicy_MayBuzz(t *testing.T) {
+	assert := assert.New(t)
+	policy, err := NewStatusPolicy(conf.StatusConf{})
+	assert.NoError(err)
+
+	door := conf.DoorConf{Id: "front"}
+	assert.True(policy.MayBuzz(door, StateMember))
+	assert.False(policy.MayBuzz(door, StateClosed))
+
+	// the door replaces the buzz action
+	garage := conf.DoorConf{Id: "garage", AllowedStatuses: []string{"closed"}}
+	assert.True(policy.MayBuzz(garage, StateClosed))
+	assert.False(policy.MayBuzz(garage, StateOpen))
+}
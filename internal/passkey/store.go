@@ -0,0 +1,191 @@
+// Package passkey keeps the WebAuthn credentials (passkeys) of the users.
+package passkey
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/jsonfile"
+	"github.com/sirupsen/logrus"
+)
+
+// the random user handle, it's stored in the passkey
+const userHandleLength = 32
+
+var logger = logrus.WithField("where", "passkey")
+
+// Credential is a passkey of a user
+type Credential struct {
+	// chosen by the user, e.g. "phone"
+	Name       string
+	Created    time.Time
+	LastUsed   time.Time
+	Credential webauthn.Credential
+}
+
+type userEntry struct {
+	Handle      []byte
+	Credentials []Credential
+}
+
+// Store contains the passkeys, by user name
+type Store struct {
+	file  string
+	mux   sync.Mutex
+	users map[string]*userEntry
+	// replaceable for tests
+	now func() time.Time
+}
+
+// NewStore loads the passkeys of the file
+func NewStore(file string) (*Store, error) {
+	store := Store{
+		file:  file,
+		users: make(map[string]*userEntry),
+		now:   time.Now,
+	}
+	if err := jsonfile.Load(file, &store.users); err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// User returns the user for the WebAuthn library. A user without passkeys gets the pendingHandle (of a started
+// registration) or a new random handle, if it's nil. The handle is saved with the first passkey.
+func (s *Store) User(userName string, pendingHandle []byte) webauthn.User {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entry, ok := s.users[userName]
+	if !ok {
+		if pendingHandle == nil {
+			pendingHandle = conf.GenerateRandomBytes(userHandleLength)
+		}
+		return &user{name: userName, entry: userEntry{Handle: pendingHandle}}
+	}
+	return &user{name: userName, entry: *entry}
+}
+
+// UserByHandle returns the user of a passkey login
+func (s *Store) UserByHandle(handle []byte) (string, webauthn.User, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for userName, entry := range s.users {
+		if bytes.Equal(entry.Handle, handle) {
+			return userName, &user{name: userName, entry: *entry}, true
+		}
+	}
+	return "", nil, false
+}
+
+// Add stores a new passkey. The user must be the one of User(), because of the user handle.
+func (s *Store) Add(webauthnUser webauthn.User, name string, credential webauthn.Credential) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	newUser := webauthnUser.(*user)
+	entry, ok := s.users[newUser.name]
+	if !ok {
+		entry = &userEntry{Handle: newUser.entry.Handle}
+		s.users[newUser.name] = entry
+	}
+	now := s.now()
+	entry.Credentials = append(entry.Credentials, Credential{Name: name, Created: now, LastUsed: now, Credential: credential})
+	s.saveLocked()
+}
+
+// Used updates the sign counter and the last use of the passkey after a login
+func (s *Store) Used(userName string, credential webauthn.Credential) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entry, ok := s.users[userName]
+	if !ok {
+		return
+	}
+	for i := range entry.Credentials {
+		if bytes.Equal(entry.Credentials[i].Credential.ID, credential.ID) {
+			entry.Credentials[i].Credential.Authenticator = credential.Authenticator
+			entry.Credentials[i].LastUsed = s.now()
+			s.saveLocked()
+			return
+		}
+	}
+}
+
+// List returns the passkeys of the user, the last used first
+func (s *Store) List(userName string) []Credential {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entry, ok := s.users[userName]
+	if !ok {
+		return nil
+	}
+	list := append([]Credential{}, entry.Credentials...)
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.After(list[j].LastUsed) })
+	return list
+}
+
+// Remove deletes a passkey of the user
+func (s *Store) Remove(userName string, credentialId []byte) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	entry, ok := s.users[userName]
+	if !ok {
+		return false
+	}
+	for i, credential := range entry.Credentials {
+		if bytes.Equal(credential.Credential.ID, credentialId) {
+			entry.Credentials = append(entry.Credentials[:i], entry.Credentials[i+1:]...)
+			if len(entry.Credentials) == 0 {
+				delete(s.users, userName)
+			}
+			s.saveLocked()
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) saveLocked() {
+	if err := jsonfile.Save(s.file, s.users); err != nil {
+		logger.WithError(err).WithField("file", s.file).Error("Can't save the passkeys.")
+	}
+}
+
+// user implements webauthn.User
+type user struct {
+	name  string
+	entry userEntry
+}
+
+func (u *user) WebAuthnID() []byte {
+	return u.entry.Handle
+}
+
+func (u *user) WebAuthnName() string {
+	return u.name
+}
+
+func (u *user) WebAuthnDisplayName() string {
+	return u.name
+}
+
+func (u *user) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.entry.Credentials))
+	for i, credential := range u.entry.Credentials {
+		credentials[i] = credential.Credential
+	}
+	return credentials
+}
+
+func (u *user) WebAuthnIcon() string {
+	return ""
+}
@@ -0,0 +1,68 @@
+package passkey
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Store_addAndFind(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "passkeys.json"))
+	assert.NoError(err)
+
+	alice := store.User("alice", nil)
+	assert.Len(alice.WebAuthnID(), userHandleLength)
+	assert.Equal([]byte{1, 2}, store.User("bob", []byte{1, 2}).WebAuthnID())
+	assert.Empty(alice.WebAuthnCredentials())
+	// not saved without a passkey
+	_, _, found := store.UserByHandle(alice.WebAuthnID())
+	assert.False(found)
+
+	store.Add(alice, "phone", webauthn.Credential{ID: []byte{1}})
+	store.Add(store.User("alice", nil), "laptop", webauthn.Credential{ID: []byte{2}})
+
+	userName, webauthnUser, found := store.UserByHandle(alice.WebAuthnID())
+	assert.True(found)
+	assert.Equal("alice", userName)
+	assert.Len(webauthnUser.WebAuthnCredentials(), 2)
+	assert.Equal(alice.WebAuthnID(), store.User("alice", nil).WebAuthnID())
+
+	// the passkeys survive a restart
+	reloaded, err := NewStore(store.file)
+	assert.NoError(err)
+	assert.Len(reloaded.List("alice"), 2)
+}
+
+func Test_Store_usedAndRemove(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "passkeys.json"))
+	assert.NoError(err)
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	store.now = testutil.Clock(&now)
+
+	store.Add(store.User("alice", nil), "phone", webauthn.Credential{ID: []byte{1}})
+	store.Add(store.User("alice", nil), "laptop", webauthn.Credential{ID: []byte{2}})
+
+	now = now.Add(time.Hour)
+	used := webauthn.Credential{ID: []byte{1}, Authenticator: webauthn.Authenticator{SignCount: 5}}
+	store.Used("alice", used)
+	list := store.List("alice")
+	assert.Equal("phone", list[0].Name)
+	assert.Equal(now, list[0].LastUsed)
+	assert.Equal(uint32(5), list[0].Credential.Authenticator.SignCount)
+
+	assert.False(store.Remove("alice", []byte{3}))
+	assert.True(store.Remove("alice", []byte{1}))
+	assert.Len(store.List("alice"), 1)
+	assert.True(store.Remove("alice", []byte{2}))
+	assert.Empty(store.List("alice"))
+}
@@ -0,0 +1,354 @@
+// Package sessionstore keeps the sessions on the server. The cookie only contains the (signed and encrypted) session
+// id, so a session can be listed and revoked.
+package sessionstore
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ginSessions "github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/ktt-ol/sesam/internal/jsonfile"
+	"github.com/sirupsen/logrus"
+)
+
+// sessions without a max age (until the browser is closed) expire after this time without a request
+const browserSessionIdle = 24 * time.Hour
+
+// sessions without a user (e.g. only the csrf token) expire after this time without a request
+const anonymousSessionIdle = time.Hour
+
+// at most this many sessions without a user are kept, the least recently used are removed first
+const maxAnonymousSessions = 10000
+
+// the changes are written to the file at most this often, except logins and revokes
+const flushInterval = time.Minute
+
+var logger = logrus.WithField("where", "sessionstore")
+
+var serializer = securecookie.GobEncoder{}
+
+// Record is a session on the server
+type Record struct {
+	Id string
+	// empty if nobody is logged in
+	User string
+	// the gob encoded session values
+	Values   []byte
+	Created  time.Time
+	LastUsed time.Time
+	Expires  time.Time
+	// the cookie has a max age (remember me), it is not removed when the browser is closed
+	Persistent bool
+	Ip         string
+	UserAgent  string
+}
+
+// Store implements the sessions.Store of gin
+type Store struct {
+	codecs  []securecookie.Codec
+	options *sessions.Options
+	file    string
+	userKey string
+	mux     sync.Mutex
+	records map[string]*Record
+	dirty   bool
+	// replaceable for tests
+	now func() time.Time
+}
+
+// NewStore loads the sessions of the file. The keyPairs are used like in securecookie.CodecsFromPairs. The userKey is
+// the session value with the user name.
+func NewStore(file string, userKey string, keyPairs ...[]byte) *Store {
+	codecs := securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range codecs {
+		if cookie, ok := codec.(*securecookie.SecureCookie); ok {
+			// the expiry is checked on the server
+			cookie.MaxAge(0)
+		}
+	}
+
+	store := Store{
+		codecs:  codecs,
+		options: &sessions.Options{Path: "/"},
+		file:    file,
+		userKey: userKey,
+		records: make(map[string]*Record),
+		now:     time.Now,
+	}
+	if err := jsonfile.Load(file, &store.records); err != nil {
+		logger.WithError(err).WithField("file", file).Error("Can't read the sessions, starting without sessions.")
+		store.records = make(map[string]*Record)
+	}
+
+	go store.flushLoop()
+
+	return &store
+}
+
+// Options sets the default options for new sessions
+func (s *Store) Options(options ginSessions.Options) {
+	s.options = &sessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+}
+
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	id, ok := s.decodeId(r, name)
+	if !ok {
+		return session, nil
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	record, ok := s.records[id]
+	if !ok || record.Expires.Before(s.now()) {
+		// revoked or expired, start a new session
+		return session, nil
+	}
+	if err := serializer.Deserialize(record.Values, &session.Values); err != nil {
+		logger.WithError(err).Error("Can't decode the session values.")
+		return session, nil
+	}
+
+	session.ID = id
+	session.IsNew = false
+	if record.Persistent {
+		// the cookie keeps its expiry when the session is saved again
+		session.Options.MaxAge = int(record.Expires.Sub(s.now()) / time.Second)
+	}
+	record.LastUsed = s.now()
+	record.Ip = clientIp(r)
+	record.UserAgent = r.UserAgent()
+	if idle := idleTime(record); record.Expires.Sub(record.LastUsed) < idle {
+		// keep browser sessions alive while they are used
+		record.Expires = record.LastUsed.Add(idle)
+	}
+	s.dirty = s.dirty || record.User != ""
+
+	return session, nil
+}
+
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			s.Revoke(session.ID)
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	values, err := serializer.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	user, _ := session.Values[s.userKey].(string)
+
+	s.mux.Lock()
+	now := s.now()
+	record, ok := s.records[session.ID]
+	if ok && record.User != user {
+		// a login gets a new id, an id known before the login (e.g. planted by an attacker) is worthless then
+		delete(s.records, session.ID)
+		ok = false
+	}
+	if !ok {
+		session.ID = newId()
+		record = &Record{Id: session.ID, Created: now}
+		s.records[session.ID] = record
+		if user == "" {
+			s.limitAnonymousLocked()
+		}
+	}
+	userChanged := record.User != user
+	record.User = user
+	record.Values = values
+	record.LastUsed = now
+	record.Ip = clientIp(r)
+	record.UserAgent = r.UserAgent()
+	record.Persistent = session.Options.MaxAge > 0 && user != ""
+	if record.Persistent {
+		record.Expires = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	} else if idle := idleTime(record); record.Expires.Sub(now) < idle {
+		record.Expires = now.Add(idle)
+	}
+	if userChanged {
+		s.saveLocked()
+	} else if user != "" {
+		s.dirty = true
+	}
+	s.mux.Unlock()
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func newId() string {
+	return base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// idleTime returns how long the session is kept without a request
+func idleTime(record *Record) time.Duration {
+	if record.User == "" {
+		return anonymousSessionIdle
+	}
+	return browserSessionIdle
+}
+
+// limitAnonymousLocked removes the least recently used sessions without a user, if there are too many. Must be
+// called with the lock held.
+func (s *Store) limitAnonymousLocked() {
+	var anonymous []*Record
+	for _, record := range s.records {
+		if record.User == "" {
+			anonymous = append(anonymous, record)
+		}
+	}
+	if len(anonymous) <= maxAnonymousSessions {
+		return
+	}
+	sort.Slice(anonymous, func(i, j int) bool { return anonymous[i].LastUsed.Before(anonymous[j].LastUsed) })
+	for _, record := range anonymous[:len(anonymous)-maxAnonymousSessions] {
+		delete(s.records, record.Id)
+	}
+}
+
+// CurrentId returns the session id of the request's cookie
+func (s *Store) CurrentId(r *http.Request, name string) string {
+	id, _ := s.decodeId(r, name)
+	return id
+}
+
+// List returns the sessions with a logged in user, of all users if user is empty. The last used first.
+func (s *Store) List(user string) []Record {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := s.now()
+	var list []Record
+	for _, record := range s.records {
+		if record.User == "" || record.Expires.Before(now) || (user != "" && record.User != user) {
+			continue
+		}
+		list = append(list, *record)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastUsed.After(list[j].LastUsed) })
+	return list
+}
+
+// Record returns the session
+func (s *Store) Record(id string) (Record, bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return Record{}, false
+	}
+	return *record, true
+}
+
+// Revoke removes the session, the next request with this session is not logged in anymore
+func (s *Store) Revoke(id string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.records[id]; !ok {
+		return
+	}
+	delete(s.records, id)
+	s.saveLocked()
+}
+
+func (s *Store) decodeId(r *http.Request, name string) (string, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return "", false
+	}
+	return id, true
+}
+
+func (s *Store) flushLoop() {
+	for range time.Tick(flushInterval) {
+		s.mux.Lock()
+		if s.dirty {
+			s.saveLocked()
+		} else {
+			// the sessions without a user are not in the file
+			s.removeExpiredLocked()
+		}
+		s.mux.Unlock()
+	}
+}
+
+// removeExpiredLocked must be called with the lock held
+func (s *Store) removeExpiredLocked() {
+	now := s.now()
+	for id, record := range s.records {
+		if record.Expires.Before(now) {
+			delete(s.records, id)
+		}
+	}
+}
+
+// saveLocked removes the expired sessions and writes the sessions with a user to the file, must be called with the
+// lock held
+func (s *Store) saveLocked() {
+	s.removeExpiredLocked()
+	loggedIn := make(map[string]*Record)
+	for id, record := range s.records {
+		if record.User != "" {
+			loggedIn[id] = record
+		}
+	}
+
+	if err := jsonfile.Save(s.file, loggedIn); err != nil {
+		logger.WithError(err).WithField("file", s.file).Error("Can't save the sessions.")
+		return
+	}
+	s.dirty = false
+}
+
+// clientIp returns the ip of the client, respecting the headers of a proxy
+func clientIp(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	if realIp := r.Header.Get("X-Real-Ip"); realIp != "" {
+		return realIp
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
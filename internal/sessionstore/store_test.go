@@ -0,0 +1,186 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const testName = "sesam"
+
+var testKeys = [][]byte{securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)}
+
+// login saves a new session with the user and returns the cookie
+func login(t *testing.T, store *Store, user string) *http.Cookie {
+	request := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(request, testName)
+	assert.NoError(t, err)
+	session.Values["userName"] = user
+
+	recorder := httptest.NewRecorder()
+	assert.NoError(t, store.Save(request, recorder, session))
+	cookies := recorder.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	return cookies[0]
+}
+
+func load(t *testing.T, store *Store, cookie *http.Cookie) *sessions.Session {
+	session, err := store.New(requestWithCookie(cookie), testName)
+	assert.NoError(t, err)
+	return session
+}
+
+func Test_Store_saveAndLoad(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+
+	cookie := login(t, store, "alice")
+	session := load(t, store, cookie)
+	assert.False(session.IsNew)
+	assert.Equal("alice", session.Values["userName"])
+
+	// the sessions survive a restart
+	reloaded := NewStore(store.file, "userName", testKeys...)
+	session = load(t, reloaded, cookie)
+	assert.False(session.IsNew)
+	assert.Equal("alice", session.Values["userName"])
+
+	// a store with other keys can't read the cookie
+	otherKeys := NewStore(store.file, "userName", securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32))
+	assert.True(load(t, otherKeys, cookie).IsNew)
+}
+
+func Test_Store_listAndRevoke(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+
+	aliceCookie := login(t, store, "alice")
+	login(t, store, "alice")
+	login(t, store, "bob")
+
+	assert.Len(store.List(""), 3)
+	aliceSessions := store.List("alice")
+	assert.Len(aliceSessions, 2)
+
+	aliceId := store.CurrentId(requestWithCookie(aliceCookie), testName)
+	assert.NotEmpty(aliceId)
+	store.Revoke(aliceId)
+	assert.Len(store.List("alice"), 1)
+	_, found := store.Record(aliceId)
+	assert.False(found)
+
+	// the revoked cookie is not logged in anymore
+	session := load(t, store, aliceCookie)
+	assert.True(session.IsNew)
+	assert.Nil(session.Values["userName"])
+}
+
+func Test_Store_expiry(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	store.now = testutil.Clock(&now)
+	cookie := login(t, store, "alice")
+
+	// used sessions are kept alive
+	now = now.Add(browserSessionIdle - time.Hour)
+	assert.False(load(t, store, cookie).IsNew)
+	now = now.Add(browserSessionIdle - time.Hour)
+	assert.False(load(t, store, cookie).IsNew)
+
+	now = now.Add(browserSessionIdle + time.Minute)
+	assert.True(load(t, store, cookie).IsNew)
+	assert.Empty(store.List(""))
+}
+
+func requestWithCookie(cookie *http.Cookie) *http.Request {
+	request := httptest.NewRequest("GET", "/", nil)
+	request.AddCookie(cookie)
+	return request
+}
+
+func Test_Store_newIdOnLogin(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+
+	// a session without a user, e.g. the csrf token of the login page
+	anonymousCookie := login(t, store, "")
+	anonymousId := store.CurrentId(requestWithCookie(anonymousCookie), testName)
+	assert.NotEmpty(anonymousId)
+
+	request := requestWithCookie(anonymousCookie)
+	session, err := store.Get(request, testName)
+	assert.NoError(err)
+	session.Values["userName"] = "alice"
+	recorder := httptest.NewRecorder()
+	assert.NoError(store.Save(request, recorder, session))
+	aliceCookie := recorder.Result().Cookies()[0]
+
+	assert.NotEqual(anonymousId, store.CurrentId(requestWithCookie(aliceCookie), testName))
+	assert.Equal("alice", load(t, store, aliceCookie).Values["userName"])
+	// the cookie from before the login is not logged in
+	session = load(t, store, anonymousCookie)
+	assert.True(session.IsNew)
+	assert.Nil(session.Values["userName"])
+}
+
+func Test_Store_anonymousNotSaved(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+
+	anonymousCookie := login(t, store, "")
+	aliceCookie := login(t, store, "alice")
+	assert.False(load(t, store, anonymousCookie).IsNew)
+
+	reloaded := NewStore(store.file, "userName", testKeys...)
+	assert.True(load(t, reloaded, anonymousCookie).IsNew)
+	assert.False(load(t, reloaded, aliceCookie).IsNew)
+}
+
+func Test_Store_keepsTheMaxAge(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store := NewStore(filepath.Join(dir, "sessions.json"), "userName", testKeys...)
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	store.now = testutil.Clock(&now)
+
+	request := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(request, testName)
+	assert.NoError(err)
+	session.Values["userName"] = "alice"
+	session.Options.MaxAge = 3600
+	recorder := httptest.NewRecorder()
+	assert.NoError(store.Save(request, recorder, session))
+	cookie := recorder.Result().Cookies()[0]
+	assert.Equal(3600, cookie.MaxAge)
+
+	// saved again by a later request, e.g. with a new value
+	now = now.Add(10 * time.Minute)
+	request = requestWithCookie(cookie)
+	session = load(t, store, cookie)
+	session.Values["other"] = "value"
+	recorder = httptest.NewRecorder()
+	assert.NoError(store.Save(request, recorder, session))
+	assert.Equal(3000, recorder.Result().Cookies()[0].MaxAge)
+	record, _ := store.Record(session.ID)
+	assert.Equal(now.Add(50*time.Minute), record.Expires)
+}
@@ -0,0 +1,23 @@
+// Package testutil contains the helpers shared by the tests of the stores.
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TempDir creates a directory for the files of a test, the returned function removes it
+func TempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "sesam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// Clock returns a replacement for time.Now, the test sets the time with the variable
+func Clock(now *time.Time) func() time.Time {
+	return func() time.Time { return *now }
+}
@@ -0,0 +1,179 @@
+// Package twofactor keeps the TOTP secrets of the users and checks their codes.
+package twofactor
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/jsonfile"
+	"github.com/pquerna/otp/totp"
+	"github.com/sirupsen/logrus"
+)
+
+// the standard of the authenticator apps
+const period = 30 * time.Second
+
+// codes of the previous and the next period are accepted, for clocks that are a bit off
+const skew = 1
+
+const qrCodeSize = 200
+
+var logger = logrus.WithField("where", "twofactor")
+
+type secret struct {
+	Secret   string
+	Enrolled time.Time
+	// the period of the last accepted code, a code can't be used twice
+	LastPeriod int64
+}
+
+// Store contains the enrolled TOTP secrets, by user name
+type Store struct {
+	file    string
+	issuer  string
+	mux     sync.Mutex
+	secrets map[string]*secret
+	// replaceable for tests
+	now func() time.Time
+}
+
+// Enrollment is a new secret that is not confirmed by the user yet
+type Enrollment struct {
+	Secret string
+	// the otpauth:// url as png, base64 encoded
+	QrCodePng string
+}
+
+// NewStore loads the secrets of the file. The issuer is shown in the authenticator app.
+func NewStore(file string, issuer string) (*Store, error) {
+	store := Store{
+		file:    file,
+		issuer:  issuer,
+		secrets: make(map[string]*secret),
+		now:     time.Now,
+	}
+	if err := jsonfile.Load(file, &store.secrets); err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// IsEnrolled returns true if the user has a second factor
+func (s *Store) IsEnrolled(userName string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	_, ok := s.secrets[userName]
+	return ok
+}
+
+// EnrolledSince returns the time of the enrollment, zero if the user has no second factor
+func (s *Store) EnrolledSince(userName string) time.Time {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if userSecret, ok := s.secrets[userName]; ok {
+		return userSecret.Enrolled
+	}
+	return time.Time{}
+}
+
+// NewEnrollment creates a new secret for the user, if pendingSecret is empty. Otherwise the QR code of the pending
+// secret is shown again. The secret is stored with Enroll, after the user confirmed it with a code.
+func (s *Store) NewEnrollment(userName string, pendingSecret string) (Enrollment, error) {
+	opts := totp.GenerateOpts{Issuer: s.issuer, AccountName: userName}
+	if pendingSecret != "" {
+		rawSecret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(pendingSecret)
+		if err != nil {
+			return Enrollment{}, err
+		}
+		opts.Secret = rawSecret
+	}
+	key, err := totp.Generate(opts)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	image, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return Enrollment{}, err
+	}
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image); err != nil {
+		return Enrollment{}, err
+	}
+
+	return Enrollment{Secret: key.Secret(), QrCodePng: base64.StdEncoding.EncodeToString(buffer.Bytes())}, nil
+}
+
+// Enroll stores the secret for the user, if the code matches
+func (s *Store) Enroll(userName string, newSecret string, code string) bool {
+	now := s.now()
+	matchedPeriod, ok := matchingPeriod(newSecret, code, now)
+	if !ok {
+		return false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.secrets[userName] = &secret{Secret: newSecret, Enrolled: now, LastPeriod: matchedPeriod}
+	s.saveLocked()
+	return true
+}
+
+// Validate checks the code of the user. Every code is accepted only once.
+func (s *Store) Validate(userName string, code string) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	userSecret, ok := s.secrets[userName]
+	if !ok {
+		return false
+	}
+	matchedPeriod, ok := matchingPeriod(userSecret.Secret, code, s.now())
+	if !ok || matchedPeriod <= userSecret.LastPeriod {
+		return false
+	}
+
+	userSecret.LastPeriod = matchedPeriod
+	s.saveLocked()
+	return true
+}
+
+// Remove deletes the second factor of the user
+func (s *Store) Remove(userName string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.secrets[userName]; !ok {
+		return
+	}
+	delete(s.secrets, userName)
+	s.saveLocked()
+}
+
+func (s *Store) saveLocked() {
+	if err := jsonfile.Save(s.file, s.secrets); err != nil {
+		logger.WithError(err).WithField("file", s.file).Error("Can't save the TOTP secrets.")
+	}
+}
+
+// matchingPeriod returns the period of the code, if it's valid around the given time
+func matchingPeriod(userSecret string, code string, now time.Time) (int64, bool) {
+	for i := -skew; i <= skew; i++ {
+		t := now.Add(time.Duration(i) * period)
+		expected, err := totp.GenerateCode(userSecret, t)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return t.Unix() / int64(period/time.Second), true
+		}
+	}
+	return 0, false
+}
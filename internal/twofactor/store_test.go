@@ -0,0 +1,61 @@
+package twofactor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func code(t *testing.T, secret string, at time.Time) string {
+	result, err := totp.GenerateCode(secret, at)
+	assert.NoError(t, err)
+	return result
+}
+
+func Test_Store_enrollAndValidate(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	store, err := NewStore(filepath.Join(dir, "totp.json"), "Sesam")
+	assert.NoError(err)
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	store.now = testutil.Clock(&now)
+
+	enrollment, err := store.NewEnrollment("alice", "")
+	assert.NoError(err)
+	assert.NotEmpty(enrollment.QrCodePng)
+	again, err := store.NewEnrollment("alice", enrollment.Secret)
+	assert.NoError(err)
+	assert.Equal(enrollment, again)
+	assert.False(store.IsEnrolled("alice"))
+
+	assert.False(store.Enroll("alice", enrollment.Secret, "000000"))
+	assert.True(store.Enroll("alice", enrollment.Secret, code(t, enrollment.Secret, now)))
+	assert.True(store.IsEnrolled("alice"))
+	assert.Equal(now, store.EnrolledSince("alice"))
+
+	// the code of the enrollment can't be used again
+	assert.False(store.Validate("alice", code(t, enrollment.Secret, now)))
+
+	// the next period and a small clock skew
+	now = now.Add(period)
+	assert.True(store.Validate("alice", code(t, enrollment.Secret, now.Add(period))))
+	assert.False(store.Validate("alice", code(t, enrollment.Secret, now)))
+	now = now.Add(5 * period)
+	assert.False(store.Validate("alice", code(t, enrollment.Secret, now.Add(-3*period))))
+	assert.True(store.Validate("alice", code(t, enrollment.Secret, now)))
+
+	assert.False(store.Validate("bob", code(t, enrollment.Secret, now)))
+
+	// the secrets survive a restart
+	reloaded, err := NewStore(store.file, "Sesam")
+	assert.NoError(err)
+	assert.True(reloaded.IsEnrolled("alice"))
+
+	store.Remove("alice")
+	assert.False(store.IsEnrolled("alice"))
+}
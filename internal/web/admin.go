@@ -0,0 +1,139 @@
+package web
+
+import (
+	"encoding/csv"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/audit"
+)
+
+// the admin page shows at most this many entries, the csv export has no limit
+const adminPageLimit = 200
+
+const dateLayout = "2006-01-02"
+
+// isAdmin returns true if the user is listed in the [Admin] section or a member of an admin group
+func (w *web) isAdmin(userName string) bool {
+	for _, user := range w.adminConf.Users {
+		if user == userName {
+			return true
+		}
+	}
+	return w.permissions.Groups().IsMemberOfAny(userName, w.adminConf.Groups)
+}
+
+// requireAdmin is a middleware for the admin pages
+func (w *web) requireAdmin(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		c.Abort()
+		return
+	}
+	if !w.isAdmin(login) {
+		logger.WithField("ip", c.ClientIP()).WithField("userName", login).Warn("Not an admin.")
+		c.String(http.StatusForbidden, "Sorry, you are not an admin.")
+		c.Abort()
+		return
+	}
+	c.Set(KEY_USER_NAME, login)
+	c.Next()
+}
+
+func (w *web) getAdmin(c *gin.Context) {
+	filter, form := auditFilterFromQuery(c)
+	filter.Limit = adminPageLimit
+
+	entries, err := w.auditLog.Query(filter)
+	if err != nil {
+		logger.WithError(err).Error("Can't query the audit log.")
+	}
+
+	rows := make([]auditRow, len(entries))
+	for i, entry := range entries {
+		rows[i] = auditRow{entry, entry.Time.Format("2006-01-02 15:04:05")}
+	}
+
+	c.HTML(http.StatusOK, "admin.html", gin.H{
+		"login":      c.GetString(KEY_USER_NAME),
+		"queryError": err != nil,
+		"entries":    rows,
+		"limited":    len(rows) == adminPageLimit,
+		"filter":     form,
+		"doors":      w.doors,
+		// the csv export with the same filter
+		"csvUrl": template.URL("/admin/audit.csv?" + c.Request.URL.RawQuery),
+	})
+}
+
+func (w *web) getAdminAuditCsv(c *gin.Context) {
+	filter, _ := auditFilterFromQuery(c)
+	entries, err := w.auditLog.Query(filter)
+	if err != nil {
+		logger.WithError(err).Error("Can't query the audit log.")
+		c.String(http.StatusInternalServerError, "Can't query the audit log.")
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=sesam-audit.csv")
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"time", "kind", "user", "door", "ip", "spaceStatus", "result", "detail"})
+	for _, entry := range entries {
+		writer.Write(csvCells(entry.Time.Format(time.RFC3339), entry.Kind, entry.User, entry.Door, entry.Ip,
+			entry.SpaceStatus, entry.Result, entry.Detail))
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		logger.WithError(err).Error("Can't write the csv.")
+	}
+}
+
+// csvCells quotes the cells a spreadsheet would run as a formula, e.g. a login like "=HYPERLINK(...)"
+func csvCells(cells ...string) []string {
+	for i, cell := range cells {
+		if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+			cells[i] = "'" + cell
+		}
+	}
+	return cells
+}
+
+type auditRow struct {
+	audit.Entry
+	FormattedTime string
+}
+
+// auditFilterForm are the query params of the filter, they are shown again in the form
+type auditFilterForm struct {
+	User string `form:"user"`
+	Door string `form:"door"`
+	Kind string `form:"kind"`
+	// yyyy-mm-dd
+	From string `form:"from"`
+	// yyyy-mm-dd, inclusive
+	To string `form:"to"`
+}
+
+func auditFilterFromQuery(c *gin.Context) (audit.Filter, auditFilterForm) {
+	var form auditFilterForm
+	c.ShouldBindQuery(&form)
+
+	filter := audit.Filter{User: form.User, Door: form.Door, Kind: form.Kind}
+	if from, err := time.ParseInLocation(dateLayout, form.From, time.Local); err == nil {
+		filter.From = from
+	} else {
+		form.From = ""
+	}
+	if to, err := time.ParseInLocation(dateLayout, form.To, time.Local); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	} else {
+		form.To = ""
+	}
+
+	return filter, form
+}
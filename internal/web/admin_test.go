@@ -0,0 +1,14 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_csvCells(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"alice", "'=HYPERLINK(\"http://evil\")", "'+1", "'-1", "'@SUM(A1)", "'\tx", "'\rx", "", "a=b"},
+		csvCells("alice", "=HYPERLINK(\"http://evil\")", "+1", "-1", "@SUM(A1)", "\tx", "\rx", "", "a=b"))
+}
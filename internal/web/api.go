@@ -0,0 +1,259 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/apitoken"
+	"github.com/ktt-ol/sesam/internal/audit"
+	"github.com/ktt-ol/sesam/internal/mqtt"
+)
+
+// the OpenAPI description of /api/v1
+const OPENAPI_FILE = "webUI/api/openapi.json"
+
+// a comment is sent this often, so proxies don't close an idle event stream
+const statusEventsKeepAlive = 30 * time.Second
+
+type apiLoginData struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Remember bool   `json:"remember"`
+	// needed if the user has a second factor
+	TotpCode string `json:"totpCode"`
+}
+
+type apiUserResponse struct {
+	Name        string `json:"name"`
+	IsAdmin     bool   `json:"isAdmin"`
+	TotpMissing bool   `json:"totpMissing"`
+	// "session" or "token"
+	AuthenticatedBy string `json:"authenticatedBy"`
+}
+
+type apiStatusResponse struct {
+	// the space state, e.g. "open", "member", "closed"
+	Status string `json:"status"`
+	// false if the status topic sent an unknown state
+	Known bool `json:"known"`
+	// true if the members can open the doors
+	IsOpen bool `json:"isOpen"`
+	// when the status was set, missing if no status was received yet
+	Since *time.Time `json:"since,omitempty"`
+	// who set the status, if known
+	SetBy string `json:"setBy,omitempty"`
+}
+
+type apiDoor struct {
+	Id        string `json:"id"`
+	Label     string `json:"label"`
+	Available bool   `json:"available"`
+	// false if the door controller doesn't send heartbeats
+	Online bool `json:"online"`
+	// true if the space status doesn't allow the door
+	Closed bool `json:"closed,omitempty"`
+	// the seconds until the cooldown or the quota allows the door again
+	RetryAfter int `json:"retryAfter,omitempty"`
+	// the start of the next allowed time window, if not available
+	NextAllowed *time.Time `json:"nextAllowed,omitempty"`
+}
+
+func addApiRoutes(router *gin.Engine, w *web) {
+	router.StaticFile("/api/v1/openapi.json", OPENAPI_FILE)
+
+	v1 := router.Group("/api/v1")
+	v1.POST("/login", w.apiPostLogin)
+	v1.POST("/logout", w.apiPostLogout)
+	v1.GET("/user", w.apiGetUser)
+	v1.GET("/status", w.apiGetStatus)
+	v1.GET("/status/events", w.apiGetStatusEvents)
+	v1.GET("/doors", w.apiGetDoors)
+	v1.PUT("/doors/:door/buzzer", w.apiPutBuzzer)
+	v1.POST("/doors/:door/buzzer", w.apiPutBuzzer)
+}
+
+// apiUser returns the user of the api request, authenticated with an API token or the session. The token is nil for
+// a session. The error response is sent, if ok is false.
+func (w *web) apiUser(c *gin.Context) (string, *apitoken.Token, bool) {
+	if bearerToken(c) != "" {
+		token, ok := w.tokenUser(c)
+		if !ok {
+			return "", nil, false
+		}
+		return token.User, &token, true
+	}
+
+	userName, ok := w.sessionUser(c)
+	if !ok {
+		sendApiError(c, http.StatusUnauthorized, "Please login first.")
+		return "", nil, false
+	}
+	return userName, nil, true
+}
+
+// apiPostLogin starts a session like the login form. Users with a second factor must send the code with the password.
+func (w *web) apiPostLogin(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	var form apiLoginData
+	if err := c.ShouldBindJSON(&form); err != nil {
+		ipLogger.WithError(err).Warn("Invalid api login.")
+		sendApiError(c, http.StatusBadRequest, "Please send the email and the password.")
+		return
+	}
+
+	userName, blockedFor, authErr := w.passwordLogin(c, form.Email, form.Password)
+	if authErr == nil && blockedFor > 0 {
+		sendApiTooManyAttempts(c, blockedFor)
+		return
+	}
+	if authErr != nil {
+		switch {
+		case authErr.SystemError:
+			sendApiError(c, http.StatusServiceUnavailable, "The login is not possible at the moment, please try later again.")
+		case blockedFor >= time.Minute:
+			sendApiTooManyAttempts(c, blockedFor)
+		default:
+			sendApiError(c, http.StatusUnauthorized, "Invalid email or password.")
+		}
+		return
+	}
+
+	method := loginMethodPassword
+	if w.totp.IsEnrolled(userName) {
+		code := strings.TrimSpace(form.TotpCode)
+		if code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"result": "error", "message": "Please send the TOTP code.", "totpRequired": true})
+			return
+		}
+		if blockedFor := w.loginThrottle.blockedFor(c.ClientIP(), totpThrottleKey(userName)); blockedFor > 0 {
+			ipLogger.WithField("userName", userName).WithField("blockedFor", blockedFor).Warn("TOTP blocked.")
+			sendApiTooManyAttempts(c, blockedFor)
+			return
+		}
+		if !w.totp.Validate(userName, code) {
+			ipLogger.WithField("userName", userName).Warn("invalid TOTP code.")
+			w.audit(c, audit.Entry{Kind: audit.KindLogin, User: userName, Result: audit.ResultFailed, Detail: "invalid TOTP code"})
+			w.loginThrottle.addFailure(c.ClientIP(), totpThrottleKey(userName))
+			c.JSON(http.StatusUnauthorized, gin.H{"result": "error", "message": "Invalid TOTP code.", "totpRequired": true})
+			return
+		}
+		w.loginThrottle.reset(totpThrottleKey(userName))
+		method = "TOTP"
+	}
+
+	w.startSession(c, userName, form.Remember, method, false)
+	c.JSON(http.StatusOK, w.apiUserResponse(userName, nil))
+}
+
+func (w *web) apiPostLogout(c *gin.Context) {
+	session := sessions.Default(c)
+	session.Clear()
+	session.Options(sessions.Options{MaxAge: -1})
+	session.Save()
+	c.Status(http.StatusNoContent)
+}
+
+func (w *web) apiGetUser(c *gin.Context) {
+	userName, token, ok := w.apiUser(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, w.apiUserResponse(userName, token))
+}
+
+func (w *web) apiUserResponse(userName string, token *apitoken.Token) apiUserResponse {
+	response := apiUserResponse{
+		Name:            userName,
+		IsAdmin:         w.isAdmin(userName),
+		TotpMissing:     w.totpMissing(userName),
+		AuthenticatedBy: "session",
+	}
+	if token != nil {
+		response.AuthenticatedBy = "token"
+	}
+	return response
+}
+
+func (w *web) apiGetStatus(c *gin.Context) {
+	if _, _, ok := w.apiUser(c); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, w.apiStatus(w.mqttHandler.CurrentStatus()))
+}
+
+func (w *web) apiStatus(status mqtt.Status) apiStatusResponse {
+	response := apiStatusResponse{
+		Status: string(status.State),
+		Known:  status.State.IsKnown(),
+		IsOpen: w.mqttHandler.Policy().Allows(mqtt.ActionBuzz, status.State),
+		SetBy:  status.SetBy,
+	}
+	if !status.Since.IsZero() {
+		response.Since = &status.Since
+	}
+	return response
+}
+
+// apiGetStatusEvents sends the current status and every change as server-sent events ("status" events with the json
+// of GET /status), until the client disconnects.
+func (w *web) apiGetStatusEvents(c *gin.Context) {
+	if _, _, ok := w.apiUser(c); !ok {
+		return
+	}
+
+	statusChanges, unsubscribe := w.mqttHandler.SubscribeStatus()
+	defer unsubscribe()
+	keepAlive := time.NewTicker(statusEventsKeepAlive)
+	defer keepAlive.Stop()
+
+	// nginx must not buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(writer io.Writer) bool {
+		select {
+		case status := <-statusChanges:
+			c.SSEvent("status", w.apiStatus(status))
+		case <-keepAlive.C:
+			io.WriteString(writer, ": keep-alive\n\n")
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
+
+// apiGetDoors returns the doors the user may open, for a token only the doors of the token
+func (w *web) apiGetDoors(c *gin.Context) {
+	userName, token, ok := w.apiUser(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	doors := []apiDoor{}
+	for _, view := range w.doorViews(userName) {
+		if token != nil && !token.MayOpen(view.Id) {
+			continue
+		}
+		door := apiDoor{Id: view.Id, Label: view.Label, Available: view.Available, Online: !view.Offline,
+			Closed: view.Closed, RetryAfter: view.WaitSeconds}
+		if view.NextAllowed != "" {
+			if next, ok := w.permissions.NextAllowed(userName, view.Id, now); ok {
+				door.NextAllowed = &next
+			}
+		}
+		doors = append(doors, door)
+	}
+	c.JSON(http.StatusOK, doors)
+}
+
+func (w *web) apiPutBuzzer(c *gin.Context) {
+	userName, token, ok := w.apiUser(c)
+	if !ok {
+		return
+	}
+	w.apiBuzz(c, userName, token, c.Param("door"))
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// why a buzz was limited, shown in the audit log
+type limitReason string
+
+const (
+	limitNone     limitReason = ""
+	limitCooldown limitReason = "cooldown"
+	limitQuota    limitReason = "quota exceeded"
+)
+
+// buzzLimiter keeps the doors from being buzzed in a loop. After a buzz, the door has a cooldown for everybody and
+// a (usually longer) cooldown for the user. Additionally, the buzzes of a user within an hour can be limited.
+type buzzLimiter struct {
+	mux          sync.Mutex
+	userCooldown time.Duration
+	doorCooldown time.Duration
+	maxPerHour   int
+	// door id -> end of the cooldown
+	doorUntil map[string]time.Time
+	// user and door id -> end of the cooldown
+	userUntil map[string]time.Time
+	// user -> the buzzes within the last hour, oldest first
+	buzzes map[string][]time.Time
+	// replaceable for tests
+	now func() time.Time
+}
+
+func newBuzzLimiter(config conf.BuzzLimitConf) *buzzLimiter {
+	return &buzzLimiter{
+		userCooldown: time.Duration(config.UserCooldownSeconds) * time.Second,
+		doorCooldown: time.Duration(config.DoorCooldownSeconds) * time.Second,
+		maxPerHour:   config.MaxPerHour,
+		doorUntil:    make(map[string]time.Time),
+		userUntil:    make(map[string]time.Time),
+		buzzes:       make(map[string][]time.Time),
+		now:          time.Now,
+	}
+}
+
+// take records a buzz of the user, if allowed. Otherwise it returns the time to wait and the reason. Attempts the
+// door controller didn't answer count, too.
+func (l *buzzLimiter) take(userName string, door conf.DoorConf) (time.Duration, limitReason) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	now := l.now()
+	l.removeExpired(now)
+	if wait, reason := l.waitLocked(userName, door.Id, now); wait > 0 {
+		return wait, reason
+	}
+
+	l.doorUntil[door.Id] = now.Add(maxDuration(l.doorCooldown, time.Duration(door.BuzzDurationMs)*time.Millisecond))
+	l.userUntil[userDoorKey(userName, door.Id)] = now.Add(l.cooldown(door))
+	if l.maxPerHour > 0 {
+		l.buzzes[userName] = append(l.buzzes[userName], now)
+	}
+	return 0, limitNone
+}
+
+// cooldown returns the time a user has to wait after opening the door
+func (l *buzzLimiter) cooldown(door conf.DoorConf) time.Duration {
+	return maxDuration(l.userCooldown, time.Duration(door.BuzzDurationMs)*time.Millisecond)
+}
+
+// waitFor returns the time until the user can open the door again, zero if now
+func (l *buzzLimiter) waitFor(userName string, doorId string) time.Duration {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	wait, _ := l.waitLocked(userName, doorId, l.now())
+	return wait
+}
+
+func (l *buzzLimiter) waitLocked(userName string, doorId string, now time.Time) (time.Duration, limitReason) {
+	var wait time.Duration
+	reason := limitNone
+	if l.maxPerHour > 0 {
+		if buzzes := l.buzzes[userName]; len(buzzes) >= l.maxPerHour {
+			// the oldest buzz that has to leave the window
+			wait = buzzes[len(buzzes)-l.maxPerHour].Add(time.Hour).Sub(now)
+			reason = limitQuota
+		}
+	}
+	for _, until := range []time.Time{l.doorUntil[doorId], l.userUntil[userDoorKey(userName, doorId)]} {
+		if left := until.Sub(now); left > wait {
+			wait = left
+			reason = limitCooldown
+		}
+	}
+	if wait <= 0 {
+		return 0, limitNone
+	}
+	return wait, reason
+}
+
+func (l *buzzLimiter) removeExpired(now time.Time) {
+	for key, until := range l.doorUntil {
+		if !until.After(now) {
+			delete(l.doorUntil, key)
+		}
+	}
+	for key, until := range l.userUntil {
+		if !until.After(now) {
+			delete(l.userUntil, key)
+		}
+	}
+	windowStart := now.Add(-time.Hour)
+	for userName, buzzes := range l.buzzes {
+		i := 0
+		for i < len(buzzes) && !buzzes[i].After(windowStart) {
+			i++
+		}
+		if i == len(buzzes) {
+			delete(l.buzzes, userName)
+		} else {
+			l.buzzes[userName] = buzzes[i:]
+		}
+	}
+}
+
+func userDoorKey(userName string, doorId string) string {
+	return userName + "\x00" + doorId
+}
+
+func maxDuration(a time.Duration, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestLimiter(config conf.BuzzLimitConf, now *time.Time) *buzzLimiter {
+	limiter := newBuzzLimiter(config)
+	limiter.now = func() time.Time { return *now }
+	return limiter
+}
+
+var outerDoor = conf.DoorConf{Id: "outer", BuzzDurationMs: 4000}
+var innerDoor = conf.DoorConf{Id: "inner", BuzzDurationMs: 4000}
+
+func Test_buzzLimiter_cooldown(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	limiter := newTestLimiter(conf.BuzzLimitConf{UserCooldownSeconds: 30}, &now)
+
+	wait, reason := limiter.take("alice", outerDoor)
+	assert.Equal(time.Duration(0), wait)
+	assert.Equal(limitNone, reason)
+
+	// the door cooldown is the buzz duration, for everybody
+	wait, reason = limiter.take("bob", outerDoor)
+	assert.Equal(4*time.Second, wait)
+	assert.Equal(limitCooldown, reason)
+	// other doors are not affected
+	wait, _ = limiter.take("alice", innerDoor)
+	assert.Equal(time.Duration(0), wait)
+
+	now = now.Add(5 * time.Second)
+	wait, _ = limiter.take("bob", outerDoor)
+	assert.Equal(time.Duration(0), wait)
+	// the user cooldown
+	assert.Equal(25*time.Second, limiter.waitFor("alice", "outer"))
+	wait, reason = limiter.take("alice", outerDoor)
+	assert.Equal(25*time.Second, wait)
+	assert.Equal(limitCooldown, reason)
+
+	now = now.Add(25 * time.Second)
+	assert.Equal(time.Duration(0), limiter.waitFor("alice", "outer"))
+}
+
+func Test_buzzLimiter_quota(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	limiter := newTestLimiter(conf.BuzzLimitConf{MaxPerHour: 2}, &now)
+	start := now
+
+	wait, _ := limiter.take("alice", outerDoor)
+	assert.Equal(time.Duration(0), wait)
+	now = now.Add(10 * time.Minute)
+	wait, _ = limiter.take("alice", innerDoor)
+	assert.Equal(time.Duration(0), wait)
+
+	now = now.Add(10 * time.Minute)
+	wait, reason := limiter.take("alice", outerDoor)
+	assert.Equal(40*time.Minute, wait)
+	assert.Equal(limitQuota, reason)
+	// the quota is per user
+	wait, _ = limiter.take("bob", outerDoor)
+	assert.Equal(time.Duration(0), wait)
+
+	now = start.Add(time.Hour)
+	wait, _ = limiter.take("alice", outerDoor)
+	assert.Equal(time.Duration(0), wait)
+	// the second buzz of the window leaves it in 10 minutes
+	wait, reason = limiter.take("alice", outerDoor)
+	assert.Equal(10*time.Minute, wait)
+	assert.Equal(limitQuota, reason)
+}
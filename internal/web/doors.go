@@ -0,0 +1,78 @@
+package web
+
+import (
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+func doorIds(doors []conf.DoorConf) []string {
+	ids := make([]string, len(doors))
+	for i, door := range doors {
+		ids[i] = door.Id
+	}
+	return ids
+}
+
+func (w *web) findDoor(id string) (conf.DoorConf, bool) {
+	for _, door := range w.doors {
+		if door.Id == id {
+			return door, true
+		}
+	}
+	return conf.DoorConf{}, false
+}
+
+// doorView is a door button in the template
+type doorView struct {
+	Id    string
+	Label string
+	// false if the door can't be opened at the moment
+	Available bool
+	// the start of the next allowed time window, if not available
+	NextAllowed string
+	// the door controller doesn't send heartbeats
+	Offline bool
+	// the space status doesn't allow the door
+	Closed bool
+	// the remaining cooldown or quota wait, 0 if the door can be opened now
+	WaitSeconds int
+	// the cooldown of the user after opening the door
+	CooldownSeconds int
+}
+
+// doorViews returns the buttons of the doors the user may open
+func (w *web) doorViews(userName string) []doorView {
+	now := time.Now()
+	var views []doorView
+	for _, door := range w.doors {
+		if !w.permissions.MayOpen(userName, door.Id) {
+			continue
+		}
+		view := doorView{Id: door.Id, Label: door.Label, Available: true}
+		if !w.permissions.MayOpenAt(userName, door.Id, now) {
+			view.Available = false
+			view.NextAllowed = w.nextAllowedText(userName, door.Id, now)
+		}
+		if !w.mqttHandler.MayBuzz(door) {
+			view.Available = false
+			view.Closed = true
+		}
+		view.WaitSeconds = retryAfterSeconds(w.limiter.waitFor(userName, door.Id))
+		view.CooldownSeconds = retryAfterSeconds(w.limiter.cooldown(door))
+		if !w.mqttHandler.DoorState(door.Id).Online {
+			view.Available = false
+			view.Offline = true
+		}
+		views = append(views, view)
+	}
+	return views
+}
+
+func (w *web) nextAllowedText(userName string, doorId string, now time.Time) string {
+	next, ok := w.permissions.NextAllowed(userName, doorId, now)
+	if !ok {
+		return "not within the next week"
+	}
+	return next.Format("Mon, 02.01. 15:04")
+}
@@ -0,0 +1,47 @@
+package web
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type healthDoor struct {
+	Id string `json:"id"`
+	// false if the door has no heartbeat topic
+	Monitored bool `json:"monitored"`
+	Online    bool `json:"online"`
+	// the last heartbeat, missing if none was received since the start
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+type healthResponse struct {
+	// "ok" or "degraded"
+	Result        string       `json:"result"`
+	MqttConnected bool         `json:"mqttConnected"`
+	Doors         []healthDoor `json:"doors"`
+}
+
+// getHealth is for the monitoring, it needs no login. It answers with 503 if the broker or a door controller is
+// offline.
+func (w *web) getHealth(c *gin.Context) {
+	response := healthResponse{Result: "ok", MqttConnected: w.mqttHandler.Connected(), Doors: []healthDoor{}}
+	healthy := response.MqttConnected
+	for _, door := range w.doors {
+		state := w.mqttHandler.DoorState(door.Id)
+		view := healthDoor{Id: door.Id, Monitored: state.Monitored, Online: state.Online}
+		if !state.LastSeen.IsZero() {
+			view.LastSeen = &state.LastSeen
+		}
+		healthy = healthy && state.Online
+		response.Doors = append(response.Doors, view)
+	}
+
+	status := http.StatusOK
+	if !healthy {
+		response.Result = "degraded"
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, response)
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/sessionstore"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/ktt-ol/sesam/internal/twofactor"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestWeb returns a web with the stores in the directory, the router has the session middleware. The main page
+// answers with the logged in user.
+func newTestWeb(t *testing.T, dir string) (*web, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	totpStore, err := twofactor.NewStore(filepath.Join(dir, "totp.json"), "Sesam")
+	assert.NoError(t, err)
+	w := &web{
+		totp:          totpStore,
+		loginThrottle: newLoginThrottle(conf.ServerConf{}),
+		sessionStore: sessionstore.NewStore(filepath.Join(dir, "sessions.json"), KEY_USER_NAME,
+			securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)),
+	}
+	w.sessionStore.Options(sessionOptions)
+
+	router := gin.New()
+	router.Use(sessions.Sessions(SESSION_NAME, w.sessionStore))
+	router.GET("/", func(c *gin.Context) {
+		userName, _ := w.sessionUser(c)
+		c.String(http.StatusOK, userName)
+	})
+	return w, router
+}
+
+// send returns the response and the session cookie of it, nil if it didn't set one. Like the browser, the last
+// cookie counts if it was set more than once.
+func send(router *gin.Engine, request *http.Request, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	var sessionCookie *http.Cookie
+	for _, setCookie := range recorder.Result().Cookies() {
+		if setCookie.Name == SESSION_NAME {
+			sessionCookie = setCookie
+		}
+	}
+	return recorder, sessionCookie
+}
+
+// assertRemembered checks the cookie of a login with "remember me", it must be sent to the main page
+func assertRemembered(t *testing.T, router *gin.Engine, cookie *http.Cookie, userName string) {
+	assert := assert.New(t)
+	if !assert.NotNil(cookie) {
+		return
+	}
+	assert.Equal("/", cookie.Path)
+	assert.Equal(REMEMBER_PASSWORD_DAYS*24*60*60, cookie.MaxAge)
+
+	response, _ := send(router, httptest.NewRequest("GET", "/", nil), cookie)
+	assert.Equal(userName, response.Body.String())
+}
+
+func Test_postLoginTotp_remember(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	w, router := newTestWeb(t, dir)
+	router.POST("/login", func(c *gin.Context) {
+		// the password was checked
+		w.finishLogin(c, "alice", true, loginMethodPassword, false)
+	})
+	router.POST("/login/totp", w.postLoginTotp)
+
+	enrollment, err := w.totp.NewEnrollment("alice", "")
+	assert.NoError(err)
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	assert.NoError(err)
+	assert.True(w.totp.Enroll("alice", enrollment.Secret, code))
+
+	response, pending := send(router, httptest.NewRequest("POST", "/login", nil), nil)
+	assert.Equal("/login/totp", response.Header().Get("Location"))
+	assert.NotNil(pending)
+
+	// the code of the enrollment can't be used again, the next one is accepted
+	code, err = totp.GenerateCode(enrollment.Secret, time.Now().Add(30*time.Second))
+	assert.NoError(err)
+	request := httptest.NewRequest("POST", "/login/totp", strings.NewReader(url.Values{"code": {code}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, cookie := send(router, request, pending)
+	assert.Equal("/", response.Header().Get("Location"))
+	assertRemembered(t, router, cookie, "alice")
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/wikiauth"
+)
+
+// the unix time of an OIDC login, the provider checked the group claim then and is asked again after the session
+// minutes
+const KEY_OIDC_LOGIN = "oidcLogin"
+
+// default, used if the server config doesn't set a value
+const defaultMembershipCheckMinutes = 15
+
+// membershipCheck asks the auth backend from time to time, if the users of the sessions are still members.
+type membershipCheck struct {
+	checker  wikiauth.MembershipChecker
+	interval time.Duration
+	mux      sync.Mutex
+	// user name -> time of the last check
+	checked map[string]time.Time
+	// replaceable for tests
+	now func() time.Time
+}
+
+// newMembershipCheck returns nil if the backend can't check the membership or the check is disabled
+func newMembershipCheck(wikiAuth wikiauth.WikiAuth, config conf.ServerConf) *membershipCheck {
+	checker, ok := wikiAuth.(wikiauth.MembershipChecker)
+	if !ok || config.MembershipCheckMinutes < 0 {
+		return nil
+	}
+	minutes := config.MembershipCheckMinutes
+	if minutes == 0 {
+		minutes = defaultMembershipCheckMinutes
+	}
+
+	return &membershipCheck{
+		checker:  checker,
+		interval: time.Duration(minutes) * time.Minute,
+		checked:  make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// isMember returns false only if the backend said the user is not a member anymore. If the backend can't answer,
+// the user stays logged in and is checked again after the interval.
+func (m *membershipCheck) isMember(userName string) bool {
+	if m == nil {
+		return true
+	}
+
+	now := m.now()
+	m.mux.Lock()
+	lastCheck, ok := m.checked[userName]
+	m.mux.Unlock()
+	if ok && now.Sub(lastCheck) < m.interval {
+		return true
+	}
+
+	isMember, err := m.checker.IsMember(userName)
+	if err != nil {
+		logger.WithError(err).WithField("userName", userName).Warn("Can't check the membership.")
+		isMember = true
+	}
+
+	m.mux.Lock()
+	if isMember {
+		m.checked[userName] = now
+	} else {
+		delete(m.checked, userName)
+	}
+	m.mux.Unlock()
+
+	return isMember
+}
+
+// sessionUser returns the logged in user of the session. If the user is not a member anymore, all sessions of the
+// user are removed.
+func (w *web) sessionUser(c *gin.Context) (string, bool) {
+	session := sessions.Default(c)
+	loginV := session.Get(KEY_USER_NAME)
+	if loginV == nil {
+		return "", false
+	}
+	userName := loginV.(string)
+
+	if oidcLoginV := session.Get(KEY_OIDC_LOGIN); oidcLoginV != nil {
+		if w.oidc.sessionValid(oidcLoginV, time.Now()) {
+			return userName, true
+		}
+		logger.WithField("ip", c.ClientIP()).WithField("userName", userName).Info("SSO session expired, the user has to login again.")
+		session.Clear()
+		session.Options(sessions.Options{MaxAge: -1})
+		session.Save()
+		return "", false
+	}
+
+	if w.membershipCheck.isMember(userName) {
+		return userName, true
+	}
+
+	logger.WithField("ip", c.ClientIP()).WithField("userName", userName).Warn("Not a member anymore, removing the sessions and API tokens.")
+	for _, record := range w.sessionStore.List(userName) {
+		w.sessionStore.Revoke(record.Id)
+	}
+	w.tokens.RevokeUser(userName)
+	session.Clear()
+	session.Options(sessions.Options{MaxAge: -1})
+	session.Save()
+
+	return "", false
+}
@@ -0,0 +1,71 @@
+package web
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/wikiauth"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeChecker struct {
+	members map[string]bool
+	err     error
+	calls   int
+}
+
+func (f *fakeChecker) CheckPassword(emailOrName string, password string) (string, *wikiauth.AuthError) {
+	return emailOrName, nil
+}
+
+func (f *fakeChecker) IsMember(userName string) (bool, error) {
+	f.calls++
+	return f.members[userName], f.err
+}
+
+func Test_membershipCheck_interval(t *testing.T) {
+	assert := assert.New(t)
+	checker := &fakeChecker{members: map[string]bool{"alice": true}}
+	check := newMembershipCheck(checker, conf.ServerConf{MembershipCheckMinutes: 10})
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	check.now = func() time.Time { return now }
+
+	assert.True(check.isMember("alice"))
+	now = now.Add(5 * time.Minute)
+	assert.True(check.isMember("alice"))
+	assert.Equal(1, checker.calls)
+
+	// alice left the group
+	checker.members["alice"] = false
+	now = now.Add(6 * time.Minute)
+	assert.False(check.isMember("alice"))
+	assert.False(check.isMember("alice"))
+	assert.Equal(3, checker.calls)
+}
+
+func Test_membershipCheck_backendDown(t *testing.T) {
+	assert := assert.New(t)
+	checker := &fakeChecker{err: errors.New("down")}
+	check := newMembershipCheck(checker, conf.ServerConf{})
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+	check.now = func() time.Time { return now }
+
+	// the users stay logged in, the backend is asked again after the interval
+	assert.True(check.isMember("alice"))
+	assert.True(check.isMember("alice"))
+	assert.Equal(1, checker.calls)
+	now = now.Add(defaultMembershipCheckMinutes * time.Minute)
+	assert.True(check.isMember("alice"))
+	assert.Equal(2, checker.calls)
+}
+
+func Test_membershipCheck_disabled(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(newMembershipCheck(&fakeChecker{}, conf.ServerConf{MembershipCheckMinutes: -1}))
+
+	var check *membershipCheck
+	assert.True(check.isMember("alice"))
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/audit"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"golang.org/x/oauth2"
+)
+
+const KEY_OIDC_STATE = "oidcState"
+const KEY_OIDC_NONCE = "oidcNonce"
+
+const oidcTimeout = 10 * time.Second
+
+// defaults, used if the config doesn't set a value
+const defaultOidcUserNameClaim = "preferred_username"
+const defaultOidcGroupsClaim = "groups"
+const defaultOidcSessionMinutes = 60
+
+var defaultOidcScopes = []string{oidc.ScopeOpenID, "profile", "email"}
+
+// oidcLogin implements the OpenID Connect authorization code flow.
+type oidcLogin struct {
+	config conf.OidcConf
+	// the provider is discovered with the first login, so sesam can start while the provider is down
+	mux          sync.Mutex
+	oauth2Config *oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+// newOidcLogin returns nil if OIDC is not configured.
+func newOidcLogin(config conf.OidcConf) *oidcLogin {
+	if config.IssuerUrl == "" {
+		return nil
+	}
+	if config.ClientId == "" || config.RedirectUrl == "" || config.RequiredGroup == "" {
+		logger.Fatal("The [Oidc] section needs 'clientId', 'redirectUrl' and 'requiredGroup'.")
+	}
+
+	if config.UserNameClaim == "" {
+		config.UserNameClaim = defaultOidcUserNameClaim
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = defaultOidcGroupsClaim
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = defaultOidcScopes
+	}
+	if config.SessionMinutes <= 0 {
+		config.SessionMinutes = defaultOidcSessionMinutes
+	}
+
+	return &oidcLogin{config: config}
+}
+
+// sessionValid returns false if the SSO login (the unix time in the session) is older than the session minutes.
+// The provider checks the group claim only at the login.
+func (o *oidcLogin) sessionValid(loginTime interface{}, now time.Time) bool {
+	if o == nil {
+		return false
+	}
+	unix, ok := loginTime.(int64)
+	if !ok {
+		return false
+	}
+	return now.Sub(time.Unix(unix, 0)) < time.Duration(o.config.SessionMinutes)*time.Minute
+}
+
+func (o *oidcLogin) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	if o.oauth2Config != nil {
+		return o.oauth2Config, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.config.IssuerUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.oauth2Config = &oauth2.Config{
+		ClientID:     o.config.ClientId,
+		ClientSecret: o.config.ClientSecret,
+		RedirectURL:  o.config.RedirectUrl,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       o.config.Scopes,
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.config.ClientId})
+
+	return o.oauth2Config, o.verifier, nil
+}
+
+// userName returns the user name of the claims, if the user is in the required group.
+func (o *oidcLogin) userName(claims map[string]interface{}) (string, error) {
+	userName, ok := claims[o.config.UserNameClaim].(string)
+	if !ok || userName == "" {
+		return "", fmt.Errorf("missing claim '%s'", o.config.UserNameClaim)
+	}
+
+	switch groups := claims[o.config.GroupsClaim].(type) {
+	case string:
+		if groups == o.config.RequiredGroup {
+			return userName, nil
+		}
+	case []interface{}:
+		for _, group := range groups {
+			if group == o.config.RequiredGroup {
+				return userName, nil
+			}
+		}
+	}
+
+	return "", errors.New("user is not in the required group")
+}
+
+func (w *web) getOidcLogin(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidcTimeout)
+	defer cancel()
+
+	oauth2Config, _, err := w.oidc.discover(ctx)
+	if err != nil {
+		logger.WithError(err).Error("OIDC discovery failed.")
+		w.renderLogin(c, http.StatusOK, gin.H{"systemError": true})
+		return
+	}
+
+	state := conf.GenerateRandomString(16)
+	nonce := conf.GenerateRandomString(16)
+	session := sessions.Default(c)
+	session.Set(KEY_OIDC_STATE, state)
+	session.Set(KEY_OIDC_NONCE, nonce)
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce)))
+}
+
+func (w *web) getOidcCallback(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+
+	session := sessions.Default(c)
+	state := session.Get(KEY_OIDC_STATE)
+	nonce := session.Get(KEY_OIDC_NONCE)
+	session.Delete(KEY_OIDC_STATE)
+	session.Delete(KEY_OIDC_NONCE)
+	session.Save()
+
+	if state == nil || c.Query("state") != state {
+		ipLogger.Warn("OIDC state mismatch.")
+		w.renderLogin(c, http.StatusBadRequest, gin.H{"ssoError": true})
+		return
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		ipLogger.WithField("error", errParam).WithField("description", c.Query("error_description")).Warn("OIDC login failed.")
+		w.renderLogin(c, http.StatusOK, gin.H{"ssoError": true})
+		return
+	}
+
+	userName, err := w.oidcUserName(c, nonce)
+	if err != nil {
+		ipLogger.WithError(err).Warn("OIDC login failed.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, Result: audit.ResultFailed, Detail: "OIDC: " + err.Error()})
+		w.renderLogin(c, http.StatusOK, gin.H{"ssoError": true})
+		return
+	}
+
+	w.finishLogin(c, userName, false, loginMethodOidc, true)
+}
+
+// oidcUserName exchanges the code and verifies the id token
+func (w *web) oidcUserName(c *gin.Context, nonce interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), oidcTimeout)
+	defer cancel()
+
+	oauth2Config, verifier, err := w.oidc.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := oauth2Config.Exchange(ctx, c.Query("code"))
+	if err != nil {
+		return "", err
+	}
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("no id_token in the token response")
+	}
+	idToken, err := verifier.Verify(ctx, rawIdToken)
+	if err != nil {
+		return "", err
+	}
+	if nonce == nil || idToken.Nonce != nonce {
+		return "", errors.New("nonce mismatch")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", err
+	}
+
+	return w.oidc.userName(claims)
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_oidcLogin_userName(t *testing.T) {
+	login := newOidcLogin(conf.OidcConf{IssuerUrl: "https://sso.example.org", ClientId: "sesam",
+		RedirectUrl: "https://sesam.example.org/login/oidc/callback", RequiredGroup: "member"})
+
+	tests := []struct {
+		name     string
+		claims   map[string]interface{}
+		userName string
+		err      string
+	}{
+		{"group as string",
+			map[string]interface{}{"preferred_username": "alice", "groups": "member"}, "alice", ""},
+		{"other group as string",
+			map[string]interface{}{"preferred_username": "alice", "groups": "guest"}, "", "user is not in the required group"},
+		{"group list",
+			map[string]interface{}{"preferred_username": "alice", "groups": []interface{}{"guest", "member"}}, "alice", ""},
+		{"group list without the group",
+			map[string]interface{}{"preferred_username": "alice", "groups": []interface{}{"guest"}}, "", "user is not in the required group"},
+		{"no groups claim",
+			map[string]interface{}{"preferred_username": "alice"}, "", "user is not in the required group"},
+		{"no user name claim",
+			map[string]interface{}{"groups": "member"}, "", "missing claim 'preferred_username'"},
+		{"empty user name",
+			map[string]interface{}{"preferred_username": "", "groups": "member"}, "", "missing claim 'preferred_username'"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			userName, err := login.userName(test.claims)
+			if test.err == "" {
+				assert.NoError(err)
+			} else {
+				assert.EqualError(err, test.err)
+			}
+			assert.Equal(test.userName, userName)
+		})
+	}
+}
+
+func Test_oidcLogin_sessionValid(t *testing.T) {
+	assert := assert.New(t)
+	login := newOidcLogin(conf.OidcConf{IssuerUrl: "https://sso.example.org", ClientId: "sesam",
+		RedirectUrl: "https://sesam.example.org/login/oidc/callback", RequiredGroup: "members", SessionMinutes: 30})
+	now := time.Date(2019, 6, 3, 10, 0, 0, 0, time.Local)
+
+	assert.True(login.sessionValid(now.Add(-29*time.Minute).Unix(), now))
+	assert.False(login.sessionValid(now.Add(-30*time.Minute).Unix(), now))
+	// sessions of older versions
+	assert.False(login.sessionValid(true, now))
+
+	// SSO was disabled
+	var disabled *oidcLogin
+	assert.False(disabled.sessionValid(now.Unix(), now))
+}
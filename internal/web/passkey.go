@@ -0,0 +1,243 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ktt-ol/sesam/internal/audit"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/passkey"
+	"github.com/utrack/gin-csrf"
+)
+
+// the state of a started registration or login, as json
+const KEY_WEBAUTHN_SESSION = "webauthnSession"
+
+// the default file name, see dataFile
+const DEFAULT_PASSKEYS_FILE = "passkeys.json"
+
+const loginMethodPasskey = "passkey"
+
+const defaultPasskeyName = "Passkey"
+const maxPasskeyNameLength = 40
+
+// passkeyLogin implements the WebAuthn registration and the login with discoverable credentials (passkeys).
+type passkeyLogin struct {
+	webauthn *webauthn.WebAuthn
+	store    *passkey.Store
+}
+
+// newPasskeyLogin returns nil if passkeys are not configured.
+func newPasskeyLogin(config conf.WebauthnConf, keysFile string) *passkeyLogin {
+	if config.RpId == "" {
+		return nil
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          config.RpId,
+		RPDisplayName: "Sesam",
+		RPOrigins:     config.RpOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid [Webauthn] config.")
+	}
+
+	file := dataFile(config.CredentialsFile, keysFile, DEFAULT_PASSKEYS_FILE)
+	store, err := passkey.NewStore(file)
+	if err != nil {
+		logger.WithError(err).WithField("file", file).Fatal("Can't read the passkeys.")
+	}
+
+	return &passkeyLogin{webauthn: webAuthn, store: store}
+}
+
+func saveWebauthnSession(session sessions.Session, data *webauthn.SessionData) error {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	session.Set(KEY_WEBAUTHN_SESSION, string(content))
+	return session.Save()
+}
+
+// takeWebauthnSession returns the state of the started registration or login, it can be used only once
+func takeWebauthnSession(session sessions.Session) (webauthn.SessionData, error) {
+	var data webauthn.SessionData
+	content, ok := session.Get(KEY_WEBAUTHN_SESSION).(string)
+	if !ok {
+		return data, errors.New("no started registration or login")
+	}
+	session.Delete(KEY_WEBAUTHN_SESSION)
+	session.Save()
+
+	err := json.Unmarshal([]byte(content), &data)
+	return data, err
+}
+
+func (w *web) postPasskeyLoginBegin(c *gin.Context) {
+	assertion, data, err := w.passkeys.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err == nil {
+		err = saveWebauthnSession(sessions.Default(c), data)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Can't begin the passkey login.")
+		c.String(http.StatusInternalServerError, "Can't begin the passkey login.")
+		return
+	}
+	c.JSON(http.StatusOK, assertion)
+}
+
+// postPasskeyLoginFinish checks the passkey. No second factor is needed, the passkey requires a user verification
+// (fingerprint, pin) on the device.
+func (w *web) postPasskeyLoginFinish(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	data, err := takeWebauthnSession(sessions.Default(c))
+	if err != nil {
+		ipLogger.WithError(err).Warn("Invalid passkey login.")
+		c.String(http.StatusBadRequest, "Please try again.")
+		return
+	}
+
+	var userName string
+	credential, err := w.passkeys.webauthn.FinishDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		name, user, found := w.passkeys.store.UserByHandle(userHandle)
+		if !found {
+			return nil, errors.New("unknown passkey")
+		}
+		userName = name
+		return user, nil
+	}, data, c.Request)
+	if err == nil && credential.Authenticator.CloneWarning {
+		err = errors.New("the sign counter went backwards, the passkey might be cloned")
+	}
+	if err != nil {
+		ipLogger.WithError(err).WithField("userName", userName).Warn("passkey login failed.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, User: userName, Result: audit.ResultFailed, Detail: "passkey: " + err.Error()})
+		c.String(http.StatusUnauthorized, "Unknown or invalid passkey.")
+		return
+	}
+	w.passkeys.store.Used(userName, *credential)
+
+	if !w.membershipCheck.isMember(userName) {
+		ipLogger.WithField("userName", userName).Warn("passkey login of a former member.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, User: userName, Result: audit.ResultDenied, Detail: "passkey: not a member"})
+		c.String(http.StatusForbidden, "Sorry, you are not a member anymore.")
+		return
+	}
+
+	w.startSession(c, userName, c.Query("remember") == "1", loginMethodPasskey, false)
+	c.String(http.StatusOK, "OK")
+}
+
+func (w *web) getPasskeys(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	type passkeyView struct {
+		Id       string
+		Name     string
+		Created  string
+		LastUsed string
+	}
+	var views []passkeyView
+	for _, credential := range w.passkeys.store.List(login) {
+		views = append(views, passkeyView{
+			Id:       base64.RawURLEncoding.EncodeToString(credential.Credential.ID),
+			Name:     credential.Name,
+			Created:  credential.Created.Format(timeLayout),
+			LastUsed: credential.LastUsed.Format(timeLayout),
+		})
+	}
+
+	c.HTML(http.StatusOK, "passkeys.html", gin.H{
+		"login":    login,
+		"passkeys": views,
+		"csrf":     csrf.GetToken(c),
+	})
+}
+
+func (w *web) postPasskeyRegisterBegin(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Please login again.")
+		return
+	}
+
+	user := w.passkeys.store.User(login, nil)
+	var exclusions []protocol.CredentialDescriptor
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, data, err := w.passkeys.webauthn.BeginRegistration(user, webauthn.WithExclusions(exclusions))
+	if err == nil {
+		err = saveWebauthnSession(sessions.Default(c), data)
+	}
+	if err != nil {
+		logger.WithError(err).Error("Can't begin the passkey registration.")
+		c.String(http.StatusInternalServerError, "Can't begin the passkey registration.")
+		return
+	}
+	c.JSON(http.StatusOK, creation)
+}
+
+func (w *web) postPasskeyRegisterFinish(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.String(http.StatusUnauthorized, "Please login again.")
+		return
+	}
+
+	data, err := takeWebauthnSession(sessions.Default(c))
+	if err != nil {
+		ipLogger.WithError(err).Warn("Invalid passkey registration.")
+		c.String(http.StatusBadRequest, "Please try again.")
+		return
+	}
+	user := w.passkeys.store.User(login, data.UserID)
+	credential, err := w.passkeys.webauthn.FinishRegistration(user, data, c.Request)
+	if err != nil {
+		ipLogger.WithError(err).WithField("userName", login).Warn("passkey registration failed.")
+		c.String(http.StatusBadRequest, "The passkey registration failed.")
+		return
+	}
+
+	name := strings.TrimSpace(c.Query("name"))
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	name = truncate(name, maxPasskeyNameLength)
+	w.passkeys.store.Add(user, name, *credential)
+	ipLogger.WithField("userName", login).WithField("name", name).Info("passkey added")
+	c.String(http.StatusOK, "OK")
+}
+
+func (w *web) postPasskeyRemove(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(c.PostForm("id"))
+	if err != nil || !w.passkeys.store.Remove(login, id) {
+		sendError(c, "Unknown passkey.")
+		return
+	}
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).Info("passkey removed")
+	c.Redirect(http.StatusSeeOther, "/passkeys")
+}
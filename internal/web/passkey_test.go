@@ -0,0 +1,126 @@
+package web
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// testAuthenticator signs the passkey logins like a device with a P-256 key
+type testAuthenticator struct {
+	key *ecdsa.PrivateKey
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	return &testAuthenticator{key: key}
+}
+
+// publicKey returns the COSE encoded public key, as stored with the passkey
+func (a *testAuthenticator) publicKey(t *testing.T) []byte {
+	coordinate := func(value *big.Int) []byte {
+		return append(make([]byte, 32-len(value.Bytes())), value.Bytes()...)
+	}
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: coordinate(a.key.X),
+		YCoord: coordinate(a.key.Y),
+	})
+	assert.NoError(t, err)
+	return publicKey
+}
+
+// assertion returns the json of navigator.credentials.get as sent by site.js
+func (a *testAuthenticator) assertion(t *testing.T, rpId string, origin string, challenge string, credentialId []byte,
+	userHandle []byte) []byte {
+	encode := base64.RawURLEncoding.EncodeToString
+	clientData, err := json.Marshal(map[string]string{"type": "webauthn.get", "challenge": challenge, "origin": origin})
+	assert.NoError(t, err)
+	rpIdHash := sha256.Sum256([]byte(rpId))
+	// user present and verified, sign count 1
+	authenticatorData := append(rpIdHash[:], 0x05, 0, 0, 0, 1)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authenticatorData...), clientDataHash[:]...))
+	r, s, err := ecdsa.Sign(rand.Reader, a.key, digest[:])
+	assert.NoError(t, err)
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	assert.NoError(t, err)
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":    encode(credentialId),
+		"rawId": encode(credentialId),
+		"type":  "public-key",
+		"response": map[string]string{
+			"authenticatorData": encode(authenticatorData),
+			"clientDataJSON":    encode(clientData),
+			"signature":         encode(signature),
+			"userHandle":        encode(userHandle),
+		},
+	})
+	assert.NoError(t, err)
+	return body
+}
+
+func Test_postPasskeyLoginFinish_remember(t *testing.T) {
+	assert := assert.New(t)
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	w, router := newTestWeb(t, dir)
+	w.passkeys = newPasskeyLogin(conf.WebauthnConf{RpId: "localhost", RpOrigins: []string{"https://localhost"},
+		CredentialsFile: filepath.Join(dir, "passkeys.json")}, "")
+	router.POST("/login/passkey/begin", w.postPasskeyLoginBegin)
+	router.POST("/login/passkey/finish", w.postPasskeyLoginFinish)
+
+	authenticator := newTestAuthenticator(t)
+	credentialId := []byte("alice's phone")
+	alice := w.passkeys.store.User("alice", nil)
+	w.passkeys.store.Add(alice, "phone", webauthn.Credential{ID: credentialId, PublicKey: authenticator.publicKey(t),
+		AttestationType: "none"})
+
+	response, pending := send(router, httptest.NewRequest("POST", "/login/passkey/begin", nil), nil)
+	var options struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	assert.NoError(json.Unmarshal(response.Body.Bytes(), &options))
+
+	body := authenticator.assertion(t, "localhost", "https://localhost", options.PublicKey.Challenge, credentialId,
+		alice.WebAuthnID())
+	request := httptest.NewRequest("POST", "/login/passkey/finish?remember=1", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response, cookie := send(router, request, pending)
+	assert.Equal("OK", response.Body.String())
+	assertRemembered(t, router, cookie, "alice")
+}
+
+func Test_truncate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("phone", truncate("phone", 5))
+	assert.Equal("pho", truncate("phone", 3))
+	assert.Equal("Schlüs", truncate("Schlüssel", 6))
+	assert.Equal("🔑🔑", truncate("🔑🔑🔑", 2))
+}
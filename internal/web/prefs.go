@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+// true if the user's name is included in the door events. Only for the current login session, a new login starts
+// without it.
+const KEY_SHARE_NAME = "shareName"
+
+// eventName returns the name for the door events, empty if the user doesn't share it. Requests with an API token
+// never share it.
+func (w *web) eventName(c *gin.Context, userName string) string {
+	if bearerToken(c) == "" && sessions.Default(c).Get(KEY_SHARE_NAME) == true {
+		return userName
+	}
+	return ""
+}
+
+func (w *web) postPrefs(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	shareName := c.PostForm("shareName") == "on"
+	session := sessions.Default(c)
+	if shareName {
+		session.Set(KEY_SHARE_NAME, true)
+	} else {
+		session.Delete(KEY_SHARE_NAME)
+	}
+	session.Save()
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).WithField("shareName", shareName).Info("preferences changed")
+	c.Redirect(http.StatusSeeOther, "/")
+}
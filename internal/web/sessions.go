@@ -0,0 +1,94 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/sessionstore"
+	"github.com/utrack/gin-csrf"
+)
+
+const SESSION_NAME = "sesam"
+
+const timeLayout = "2006-01-02 15:04"
+
+type sessionView struct {
+	Id        string
+	User      string
+	Created   string
+	LastUsed  string
+	Expires   string
+	Ip        string
+	UserAgent string
+	// the session of this request
+	Current bool
+}
+
+func (w *web) getSessions(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	w.renderSessions(c, login, w.sessionStore.List(login), false)
+}
+
+func (w *web) getAdminSessions(c *gin.Context) {
+	w.renderSessions(c, c.GetString(KEY_USER_NAME), w.sessionStore.List(""), true)
+}
+
+func (w *web) renderSessions(c *gin.Context, login string, records []sessionstore.Record, adminView bool) {
+	currentId := w.sessionStore.CurrentId(c.Request, SESSION_NAME)
+	views := make([]sessionView, len(records))
+	for i, record := range records {
+		views[i] = sessionView{
+			Id:        record.Id,
+			User:      record.User,
+			Created:   record.Created.Format(timeLayout),
+			LastUsed:  record.LastUsed.Format(timeLayout),
+			Expires:   record.Expires.Format(timeLayout),
+			Ip:        record.Ip,
+			UserAgent: record.UserAgent,
+			Current:   record.Id == currentId,
+		}
+	}
+
+	c.HTML(http.StatusOK, "sessions.html", gin.H{
+		"login":     login,
+		"sessions":  views,
+		"adminView": adminView,
+		"isAdmin":   w.isAdmin(login),
+		"csrf":      csrf.GetToken(c),
+	})
+}
+
+// postRevokeSession removes a session of the user. Admins can remove every session.
+func (w *web) postRevokeSession(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	record, found := w.sessionStore.Record(c.PostForm("id"))
+	if !found {
+		sendError(c, "Unknown session.")
+		return
+	}
+	if record.User != login && !w.isAdmin(login) {
+		ipLogger.WithField("userName", login).Warn("Not allowed to revoke the session.")
+		c.String(http.StatusForbidden, "Sorry, you can't revoke this session.")
+		return
+	}
+
+	w.sessionStore.Revoke(record.Id)
+	ipLogger.WithField("userName", login).WithField("sessionUser", record.User).Info("session revoked")
+
+	if c.PostForm("admin") != "" {
+		c.Redirect(http.StatusSeeOther, "/admin/sessions")
+	} else {
+		c.Redirect(http.StatusSeeOther, "/sessions")
+	}
+}
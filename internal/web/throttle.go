@@ -0,0 +1,133 @@
+package web
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// defaults, used if the server config doesn't set a value
+const defaultLoginFailWindowMinutes = 15
+const defaultLoginMaxFailures = 10
+const defaultLoginBanMinutes = 30
+
+// loginThrottle counts failed logins per client ip and per login name. Every failure blocks further attempts
+// for an exponential growing time (1s, 2s, 4s, ...). After too many failures within the window, the client or login
+// is banned for a longer time.
+type loginThrottle struct {
+	mux         sync.Mutex
+	window      time.Duration
+	banDuration time.Duration
+	maxFailures int
+	entries     map[string]*failureEntry
+	// replaceable for tests
+	now func() time.Time
+}
+
+type failureEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+func newLoginThrottle(config conf.ServerConf) *loginThrottle {
+	windowMinutes := config.LoginFailWindowMinutes
+	if windowMinutes <= 0 {
+		windowMinutes = defaultLoginFailWindowMinutes
+	}
+	maxFailures := config.LoginMaxFailures
+	if maxFailures <= 0 {
+		maxFailures = defaultLoginMaxFailures
+	}
+	banMinutes := config.LoginBanMinutes
+	if banMinutes <= 0 {
+		banMinutes = defaultLoginBanMinutes
+	}
+
+	return &loginThrottle{
+		window:      time.Duration(windowMinutes) * time.Minute,
+		banDuration: time.Duration(banMinutes) * time.Minute,
+		maxFailures: maxFailures,
+		entries:     make(map[string]*failureEntry),
+		now:         time.Now,
+	}
+}
+
+// blockedFor returns the remaining time the client ip or the login is blocked. Zero means not blocked.
+func (t *loginThrottle) blockedFor(ip string, login string) time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := t.now()
+	var remaining time.Duration
+	for _, key := range throttleKeys(ip, login) {
+		entry, ok := t.entries[key]
+		if !ok {
+			continue
+		}
+		if left := entry.blockedUntil.Sub(now); left > remaining {
+			remaining = left
+		}
+	}
+	return remaining
+}
+
+// addFailure records a failed login for the client ip and the login name.
+func (t *loginThrottle) addFailure(ip string, login string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := t.now()
+	t.removeExpired(now)
+
+	for _, key := range throttleKeys(ip, login) {
+		entry, ok := t.entries[key]
+		if !ok {
+			entry = &failureEntry{}
+			t.entries[key] = entry
+		}
+		entry.failures++
+		entry.lastFailure = now
+
+		if entry.failures >= t.maxFailures {
+			entry.blockedUntil = now.Add(t.banDuration)
+			continue
+		}
+		backoff := time.Second << uint(entry.failures-1)
+		if backoff > t.banDuration {
+			backoff = t.banDuration
+		}
+		entry.blockedUntil = now.Add(backoff)
+	}
+}
+
+// reset forgets the failures of the login, e.g. after a successful login. The client ip is not reset, otherwise
+// somebody with a valid account could guess the passwords of other users.
+func (t *loginThrottle) reset(login string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.entries, loginKey(login))
+}
+
+func (t *loginThrottle) removeExpired(now time.Time) {
+	for key, entry := range t.entries {
+		if entry.lastFailure.Add(t.window).Before(now) && entry.blockedUntil.Before(now) {
+			delete(t.entries, key)
+		}
+	}
+}
+
+// throttleKeys returns only the ip key for an empty login
+func throttleKeys(ip string, login string) []string {
+	if login == "" {
+		return []string{"ip:" + ip}
+	}
+	return []string{"ip:" + ip, loginKey(login)}
+}
+
+func loginKey(login string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
@@ -0,0 +1,84 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestThrottle(now *time.Time) *loginThrottle {
+	throttle := newLoginThrottle(conf.ServerConf{LoginFailWindowMinutes: 10, LoginMaxFailures: 3, LoginBanMinutes: 30})
+	throttle.now = func() time.Time { return *now }
+	return throttle
+}
+
+func Test_loginThrottle_backoffAndBan(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	throttle := newTestThrottle(&now)
+
+	assert.Equal(time.Duration(0), throttle.blockedFor("1.2.3.4", "alice"))
+
+	throttle.addFailure("1.2.3.4", "alice")
+	assert.Equal(time.Second, throttle.blockedFor("1.2.3.4", "alice"))
+	throttle.addFailure("1.2.3.4", "alice")
+	assert.Equal(2*time.Second, throttle.blockedFor("1.2.3.4", "alice"))
+
+	now = now.Add(5 * time.Second)
+	assert.Equal(time.Duration(0), throttle.blockedFor("1.2.3.4", "alice"))
+
+	throttle.addFailure("1.2.3.4", "alice")
+	assert.Equal(30*time.Minute, throttle.blockedFor("1.2.3.4", "alice"))
+	// the ban applies to the ip and to the login
+	assert.Equal(30*time.Minute, throttle.blockedFor("5.6.7.8", "ALICE"))
+	assert.Equal(30*time.Minute, throttle.blockedFor("1.2.3.4", "bob"))
+	assert.Equal(time.Duration(0), throttle.blockedFor("5.6.7.8", "bob"))
+
+	now = now.Add(31 * time.Minute)
+	assert.Equal(time.Duration(0), throttle.blockedFor("1.2.3.4", "alice"))
+	// the window has passed, the counting starts again
+	throttle.addFailure("1.2.3.4", "alice")
+	assert.Equal(time.Second, throttle.blockedFor("1.2.3.4", "alice"))
+}
+
+func Test_loginThrottle_reset(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	throttle := newTestThrottle(&now)
+
+	throttle.addFailure("1.2.3.4", "alice")
+	throttle.addFailure("1.2.3.4", "alice")
+	throttle.reset("alice")
+
+	// the login is reset, but not the ip
+	assert.Equal(time.Duration(0), throttle.blockedFor("5.6.7.8", "alice"))
+	assert.Equal(2*time.Second, throttle.blockedFor("1.2.3.4", "bob"))
+}
+
+func Test_loginThrottle_totpNotResetByPassword(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	throttle := newTestThrottle(&now)
+
+	// wrong codes from changing ips, each after a correct password
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"} {
+		throttle.reset("alice")
+		throttle.addFailure(ip, totpThrottleKey("alice"))
+	}
+	throttle.reset("alice")
+	assert.Equal(time.Duration(0), throttle.blockedFor("4.4.4.4", "alice"))
+	assert.Equal(30*time.Minute, throttle.blockedFor("4.4.4.4", totpThrottleKey("alice")))
+}
+
+func Test_loginThrottle_emptyLogin(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Unix(1000, 0)
+	throttle := newTestThrottle(&now)
+
+	// e.g. unparsable API tokens, only the ip is blocked
+	throttle.addFailure("1.2.3.4", "")
+	assert.Equal(time.Second, throttle.blockedFor("1.2.3.4", ""))
+	assert.Equal(time.Duration(0), throttle.blockedFor("5.6.7.8", ""))
+}
@@ -0,0 +1,286 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/apitoken"
+	"github.com/ktt-ol/sesam/internal/audit"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/mqtt"
+	"github.com/utrack/gin-csrf"
+)
+
+// the default file name, see dataFile
+const DEFAULT_TOKENS_FILE = "tokens.json"
+
+// the api routes are called by scripts, they are authenticated with a token instead of a session and csrf token
+const apiPathPrefix = "/api/"
+
+const defaultTokenName = "API token"
+const maxTokenNameLength = 40
+
+// the longest expiry that can be chosen, 0 means the token never expires
+const maxTokenExpiresDays = 3650
+
+func newTokenStore(config conf.ServerConf) *apitoken.Store {
+	file := dataFile(config.TokensFile, config.KeysFile, DEFAULT_TOKENS_FILE)
+	store, err := apitoken.NewStore(file)
+	if err != nil {
+		logger.WithError(err).WithField("file", file).Fatal("Can't read the API tokens.")
+	}
+	return store
+}
+
+// csrfUnlessApi checks the csrf token for all routes except the api routes. Api requests with a session cookie must
+// send json instead: a foreign site can't send a json request without a CORS preflight, which is never allowed.
+func csrfUnlessApi(csrfMiddleware gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, apiPathPrefix) {
+			csrfMiddleware(c)
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+		if bearerToken(c) == "" && c.ContentType() != gin.MIMEJSON {
+			logger.WithField("ip", c.ClientIP()).Warn("api request without token or json")
+			sendApiError(c, http.StatusUnsupportedMediaType, "Please send a json request.")
+			c.Abort()
+		}
+	}
+}
+
+type tokenView struct {
+	Id       string
+	Name     string
+	Doors    string
+	Created  string
+	Expires  string
+	LastUsed string
+	Expired  bool
+}
+
+func (w *web) getTokens(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	w.renderTokens(c, login, gin.H{})
+}
+
+// postTokens creates a new token, the secret is shown only once
+func (w *web) postTokens(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		name = defaultTokenName
+	}
+	name = truncate(name, maxTokenNameLength)
+
+	doors := c.PostFormArray("doors")
+	for _, door := range doors {
+		if !w.permissions.MayOpen(login, door) {
+			sendError(c, "Invalid door.")
+			return
+		}
+	}
+	if len(doors) == 0 {
+		w.renderTokens(c, login, gin.H{"error": "Please choose at least one door."})
+		return
+	}
+
+	days, err := strconv.Atoi(c.PostForm("expiresDays"))
+	if err != nil || days < 0 || days > maxTokenExpiresDays {
+		sendError(c, "Invalid expiry.")
+		return
+	}
+	var expires time.Time
+	if days > 0 {
+		expires = time.Now().AddDate(0, 0, days)
+	}
+
+	value, token := w.tokens.Create(login, name, doors, expires)
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).WithField("token", token.Id).Info("API token created")
+	w.renderTokens(c, login, gin.H{"newToken": value, "newTokenName": name})
+}
+
+func (w *web) postRevokeToken(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	id := c.PostForm("id")
+	if !w.tokens.Revoke(login, id) {
+		sendError(c, "Unknown token.")
+		return
+	}
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).WithField("token", id).Info("API token revoked")
+	c.Redirect(http.StatusSeeOther, "/tokens")
+}
+
+func (w *web) renderTokens(c *gin.Context, login string, data gin.H) {
+	now := time.Now()
+	doors := w.doorViews(login)
+	labels := make(map[string]string)
+	for _, door := range doors {
+		labels[door.Id] = door.Label
+	}
+
+	var views []tokenView
+	for _, token := range w.tokens.List(login) {
+		doorLabels := make([]string, len(token.Doors))
+		for i, door := range token.Doors {
+			doorLabels[i] = door
+			if label, ok := labels[door]; ok {
+				doorLabels[i] = label
+			}
+		}
+		view := tokenView{
+			Id:       token.Id,
+			Name:     token.Name,
+			Doors:    strings.Join(doorLabels, ", "),
+			Created:  token.Created.Format(timeLayout),
+			Expires:  "never",
+			LastUsed: "never",
+			Expired:  token.IsExpired(now),
+		}
+		if !token.Expires.IsZero() {
+			view.Expires = token.Expires.Format(timeLayout)
+		}
+		if !token.LastUsed.IsZero() {
+			view.LastUsed = token.LastUsed.Format(timeLayout)
+		}
+		views = append(views, view)
+	}
+
+	data["login"] = login
+	data["tokens"] = views
+	data["doors"] = doors
+	data["csrf"] = csrf.GetToken(c)
+	c.HTML(http.StatusOK, "tokens.html", data)
+}
+
+// bearerToken returns the token of the "Authorization: Bearer <token>" header
+func bearerToken(c *gin.Context) string {
+	const prefix = "Bearer "
+	header := c.GetHeader("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// sendApiError answers an api request with {"result": "error", "message": "..."}
+func sendApiError(c *gin.Context, httpStatus int, message string) {
+	c.JSON(httpStatus, gin.H{"result": "error", "message": message})
+}
+
+func sendApiTooManyAttempts(c *gin.Context, blockedFor time.Duration) {
+	setRetryAfter(c, blockedFor)
+	sendApiError(c, http.StatusTooManyRequests, "Too many attempts, please try later again.")
+}
+
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+}
+
+// retryAfterSeconds rounds up, a client must not retry too early
+func retryAfterSeconds(wait time.Duration) int {
+	seconds := int(wait / time.Second)
+	if wait%time.Second > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+// tokenUser authenticates the api request with the API token of the Authorization header. The error response is
+// sent, if ok is false.
+func (w *web) tokenUser(c *gin.Context) (apitoken.Token, bool) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	value := bearerToken(c)
+	// the failures are counted per token id and client ip, values without an id only for the client ip
+	id, _ := apitoken.ParseId(value)
+	throttleKey := ""
+	if id != "" {
+		throttleKey = "token:" + id
+	}
+
+	if blockedFor := w.loginThrottle.blockedFor(c.ClientIP(), throttleKey); blockedFor > 0 {
+		ipLogger.WithField("token", id).WithField("blockedFor", blockedFor).Warn("API token blocked.")
+		sendApiTooManyAttempts(c, blockedFor)
+		return apitoken.Token{}, false
+	}
+
+	token, ok := w.tokens.Authenticate(value)
+	if !ok {
+		ipLogger.WithField("token", id).Warn("invalid API token.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, Result: audit.ResultFailed, Detail: "invalid API token"})
+		w.loginThrottle.addFailure(c.ClientIP(), throttleKey)
+		sendApiError(c, http.StatusUnauthorized, "Unknown or expired token.")
+		return apitoken.Token{}, false
+	}
+
+	if !w.membershipCheck.isMember(token.User) {
+		ipLogger.WithField("userName", token.User).Warn("Not a member anymore, removing the API tokens.")
+		w.tokens.RevokeUser(token.User)
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, User: token.User, Result: audit.ResultDenied,
+			Detail: "API token " + token.Name + ", not a member"})
+		sendApiError(c, http.StatusForbidden, "Sorry, you are not a member anymore.")
+		return apitoken.Token{}, false
+	}
+	return token, true
+}
+
+// putTokenBuzzer is the PUT /buzzer for scripts, authenticated with an API token
+func (w *web) putTokenBuzzer(c *gin.Context) {
+	token, ok := w.tokenUser(c)
+	if !ok {
+		return
+	}
+	w.apiBuzz(c, token.User, &token, c.Query("door"))
+}
+
+// apiBuzz opens the door and sends the json response. The token is nil for a session.
+func (w *web) apiBuzz(c *gin.Context, userName string, token *apitoken.Token, doorId string) {
+	var detail string
+	if token != nil {
+		detail = "API token " + token.Name
+		if !token.MayOpen(doorId) {
+			logger.WithField("ip", c.ClientIP()).WithField("userName", userName).WithField("door", doorId).Warn("door not in the token scope")
+			w.audit(c, audit.Entry{Kind: audit.KindBuzz, User: userName, Door: doorId, Result: audit.ResultDenied,
+				Detail: joinDetail(detail, "not in the token scope")})
+			sendApiError(c, http.StatusForbidden, "Sorry, the token can't open this door.")
+			return
+		}
+	}
+
+	err := w.buzz(c, userName, doorId, detail)
+	switch denied := err.(type) {
+	case nil:
+		c.JSON(http.StatusOK, gin.H{"result": "ok", "buzzResult": mqtt.BuzzOk})
+	case *buzzDenied:
+		if denied.retryAfter > 0 {
+			setRetryAfter(c, denied.retryAfter)
+			c.JSON(denied.httpStatus, gin.H{"result": "error", "message": denied.message, "retryAfter": retryAfterSeconds(denied.retryAfter)})
+			return
+		}
+		sendApiError(c, denied.httpStatus, strings.TrimPrefix(denied.message, "Error: "))
+	case *buzzFailed:
+		c.JSON(denied.httpStatus(), gin.H{"result": "error", "message": denied.message(), "buzzResult": denied.result})
+	default:
+		sendApiError(c, http.StatusBadGateway, "The door didn't react, please try again.")
+	}
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_csrfUnlessApi(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	csrfChecked := false
+	router := gin.New()
+	router.Use(csrfUnlessApi(func(c *gin.Context) {
+		csrfChecked = true
+	}))
+	router.Any("/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, "OK")
+	})
+
+	send := func(method string, path string, contentType string, token string) int {
+		csrfChecked = false
+		request := httptest.NewRequest(method, path, strings.NewReader("{}"))
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// the html routes use the csrf token
+	assert.Equal(http.StatusOK, send("POST", "/login", "application/x-www-form-urlencoded", ""))
+	assert.True(csrfChecked)
+
+	// the api routes need a token or json
+	assert.Equal(http.StatusOK, send("GET", "/api/v1/doors", "", ""))
+	assert.Equal(http.StatusOK, send("PUT", "/api/v1/doors/outer/buzzer", "application/json", ""))
+	assert.Equal(http.StatusOK, send("POST", "/api/token/buzzer", "", "sesam_id.secret"))
+	assert.False(csrfChecked)
+	assert.Equal(http.StatusUnsupportedMediaType, send("POST", "/api/v1/login", "application/x-www-form-urlencoded", ""))
+	assert.Equal(http.StatusUnsupportedMediaType, send("POST", "/api/v1/login", "text/plain", ""))
+}
+
+func Test_bearerToken(t *testing.T) {
+	assert := assert.New(t)
+	gin.SetMode(gin.TestMode)
+
+	tokenOf := func(header string) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.Header.Set("Authorization", header)
+		return bearerToken(c)
+	}
+	assert.Equal("sesam_a.b", tokenOf("Bearer sesam_a.b"))
+	assert.Equal("sesam_a.b", tokenOf("bearer  sesam_a.b "))
+	assert.Equal("", tokenOf("Basic YWxpY2U6c2VjcmV0"))
+	assert.Equal("", tokenOf(""))
+}
@@ -0,0 +1,207 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/audit"
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/twofactor"
+	"github.com/utrack/gin-csrf"
+)
+
+// the login waits for the TOTP code
+const KEY_TOTP_PENDING_USER = "totpPendingUser"
+const KEY_TOTP_PENDING_SINCE = "totpPendingSince"
+const KEY_TOTP_PENDING_REMEMBER = "totpPendingRemember"
+const KEY_TOTP_PENDING_METHOD = "totpPendingMethod"
+const KEY_TOTP_PENDING_OIDC = "totpPendingOidc"
+
+// the secret of an enrollment that is not confirmed yet
+const KEY_TOTP_ENROLL_SECRET = "totpEnrollSecret"
+
+// the default file name, see dataFile
+const DEFAULT_TOTP_FILE = "totp.json"
+
+const defaultTotpIssuer = "Sesam"
+
+// the code must be entered within this time after the password
+const totpPendingTimeout = 5 * time.Minute
+
+func newTotpStore(config conf.TotpConf, keysFile string) *twofactor.Store {
+	file := dataFile(config.SecretsFile, keysFile, DEFAULT_TOTP_FILE)
+	issuer := config.Issuer
+	if issuer == "" {
+		issuer = defaultTotpIssuer
+	}
+
+	store, err := twofactor.NewStore(file, issuer)
+	if err != nil {
+		logger.WithError(err).WithField("file", file).Fatal("Can't read the TOTP secrets.")
+	}
+	return store
+}
+
+// totpMissing returns true if the user must have a second factor to open doors, but has none
+func (w *web) totpMissing(userName string) bool {
+	return !w.totp.IsEnrolled(userName) && w.permissions.Groups().IsMemberOfAny(userName, w.totpConf.RequiredGroups)
+}
+
+// totpThrottleKey counts the wrong codes apart from the passwords, a correct password doesn't reset them
+func totpThrottleKey(userName string) string {
+	return "totp:" + userName
+}
+
+// pendingTotpUser returns the user who entered the password, but not the code yet
+func pendingTotpUser(session sessions.Session) (string, bool) {
+	userName, ok := session.Get(KEY_TOTP_PENDING_USER).(string)
+	if !ok {
+		return "", false
+	}
+	since, _ := session.Get(KEY_TOTP_PENDING_SINCE).(int64)
+	if time.Since(time.Unix(since, 0)) > totpPendingTimeout {
+		return "", false
+	}
+	return userName, true
+}
+
+func (w *web) getLoginTotp(c *gin.Context) {
+	if _, ok := pendingTotpUser(sessions.Default(c)); !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	w.renderLoginTotp(c, http.StatusOK, gin.H{})
+}
+
+func (w *web) postLoginTotp(c *gin.Context) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	session := sessions.Default(c)
+	userName, ok := pendingTotpUser(session)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	if blockedFor := w.loginThrottle.blockedFor(c.ClientIP(), totpThrottleKey(userName)); blockedFor > 0 {
+		ipLogger.WithField("userName", userName).WithField("blockedFor", blockedFor).Warn("TOTP blocked.")
+		w.renderLoginTotp(c, http.StatusTooManyRequests, gin.H{"tooManyAttempts": true})
+		return
+	}
+
+	if !w.totp.Validate(userName, strings.TrimSpace(c.PostForm("code"))) {
+		ipLogger.WithField("userName", userName).Warn("invalid TOTP code.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, User: userName, Result: audit.ResultFailed, Detail: "invalid TOTP code"})
+		w.loginThrottle.addFailure(c.ClientIP(), totpThrottleKey(userName))
+		w.renderLoginTotp(c, http.StatusOK, gin.H{"error": true})
+		return
+	}
+
+	remember, _ := session.Get(KEY_TOTP_PENDING_REMEMBER).(bool)
+	method, _ := session.Get(KEY_TOTP_PENDING_METHOD).(string)
+	oidcLogin, _ := session.Get(KEY_TOTP_PENDING_OIDC).(bool)
+	session.Delete(KEY_TOTP_PENDING_USER)
+	session.Delete(KEY_TOTP_PENDING_SINCE)
+	session.Delete(KEY_TOTP_PENDING_REMEMBER)
+	session.Delete(KEY_TOTP_PENDING_METHOD)
+	session.Delete(KEY_TOTP_PENDING_OIDC)
+
+	w.loginThrottle.reset(totpThrottleKey(userName))
+	if method == loginMethodPassword {
+		method = "TOTP"
+	} else {
+		method += ", TOTP"
+	}
+	w.startSession(c, userName, remember, method, oidcLogin)
+	c.Redirect(http.StatusSeeOther, "/")
+}
+
+func (w *web) renderLoginTotp(c *gin.Context, httpStatus int, data gin.H) {
+	data["csrf"] = csrf.GetToken(c)
+	c.HTML(httpStatus, "totp_login.html", data)
+}
+
+// getTotp shows the second factor of the user or the QR code for the enrollment
+func (w *web) getTotp(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	w.renderTotp(c, login, gin.H{})
+}
+
+// postTotp finishes the enrollment
+func (w *web) postTotp(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	session := sessions.Default(c)
+	secret, _ := session.Get(KEY_TOTP_ENROLL_SECRET).(string)
+	if secret == "" || w.totp.IsEnrolled(login) {
+		c.Redirect(http.StatusSeeOther, "/totp")
+		return
+	}
+	if !w.totp.Enroll(login, secret, strings.TrimSpace(c.PostForm("code"))) {
+		w.renderTotp(c, login, gin.H{"error": true})
+		return
+	}
+
+	session.Delete(KEY_TOTP_ENROLL_SECRET)
+	session.Save()
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).Info("TOTP enrolled")
+	c.Redirect(http.StatusSeeOther, "/totp")
+}
+
+// postTotpRemove removes the second factor, it needs a valid code
+func (w *web) postTotpRemove(c *gin.Context) {
+	login, ok := w.sessionUser(c)
+	if !ok {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	if !w.totp.Validate(login, strings.TrimSpace(c.PostForm("code"))) {
+		w.renderTotp(c, login, gin.H{"error": true})
+		return
+	}
+
+	w.totp.Remove(login)
+	logger.WithField("ip", c.ClientIP()).WithField("userName", login).Info("TOTP removed")
+	c.Redirect(http.StatusSeeOther, "/totp")
+}
+
+func (w *web) renderTotp(c *gin.Context, login string, data gin.H) {
+	data["login"] = login
+	data["csrf"] = csrf.GetToken(c)
+	data["required"] = w.permissions.Groups().IsMemberOfAny(login, w.totpConf.RequiredGroups)
+
+	if w.totp.IsEnrolled(login) {
+		data["enrolled"] = true
+		data["since"] = w.totp.EnrolledSince(login).Format(timeLayout)
+		c.HTML(http.StatusOK, "totp.html", data)
+		return
+	}
+
+	// the same secret is shown again, until the enrollment is finished
+	session := sessions.Default(c)
+	secret, _ := session.Get(KEY_TOTP_ENROLL_SECRET).(string)
+	enrollment, err := w.totp.NewEnrollment(login, secret)
+	if err != nil {
+		logger.WithError(err).Error("Can't create the TOTP enrollment.")
+		c.String(http.StatusInternalServerError, "Can't create the TOTP secret.")
+		return
+	}
+	session.Set(KEY_TOTP_ENROLL_SECRET, enrollment.Secret)
+	session.Save()
+
+	data["secret"] = enrollment.Secret
+	data["qrCode"] = template.URL("data:image/png;base64," + enrollment.QrCodePng)
+	c.HTML(http.StatusOK, "totp.html", data)
+}
@@ -3,31 +3,113 @@ package web
 import (
 	"fmt"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
+	"github.com/ktt-ol/sesam/internal/access"
+	"github.com/ktt-ol/sesam/internal/apitoken"
+	"github.com/ktt-ol/sesam/internal/audit"
 	"github.com/ktt-ol/sesam/internal/conf"
 	"github.com/ktt-ol/sesam/internal/mqtt"
+	"github.com/ktt-ol/sesam/internal/sessionstore"
+	"github.com/ktt-ol/sesam/internal/twofactor"
 	"github.com/ktt-ol/sesam/internal/wikiauth"
 	"github.com/sirupsen/logrus"
 	"github.com/utrack/gin-csrf"
 	"net/http"
+	"path/filepath"
 	"time"
 )
 
 const KEY_USER_NAME = "userName"
+
+// the login methods, shown in the audit log
+const loginMethodPassword = ""
+const loginMethodOidc = "OIDC"
 const REMEMBER_PASSWORD_DAYS = 180;
 
+// the default file names, see dataFile
+const DEFAULT_SESSIONS_FILE = "sessions.json"
+
 var logger = logrus.WithField("where", "web")
 
+// truncate cuts the text after maxRunes characters, a multi-byte character is not split
+func truncate(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes])
+}
+
+// the session cookie, a login with "remember me" only changes the max age
+var sessionOptions = sessions.Options{Path: "/", HttpOnly: true, Secure: true}
+
+// dataFile returns the configured file or, if not configured, the file with the default name next to the keys file
+func dataFile(configured string, keysFile string, defaultName string) string {
+	if configured != "" {
+		return configured
+	}
+	return filepath.Join(filepath.Dir(keysFile), defaultName)
+}
+
 type web struct {
-	wikiData    wikiauth.WikiAuth
-	mqttHandler *mqtt.MqttHandler
+	wikiData      wikiauth.WikiAuth
+	mqttHandler   *mqtt.MqttHandler
+	doors         []conf.DoorConf // in the order they are shown
+	loginThrottle *loginThrottle
+	// nil if disabled
+	oidc        *oidcLogin
+	permissions *access.Permissions
+	// nil if disabled
+	auditLog     *audit.Log
+	adminConf    conf.AdminConf
+	sessionStore *sessionstore.Store
+	// nil if the backend can't check the membership
+	membershipCheck *membershipCheck
+	totp            *twofactor.Store
+	totpConf        conf.TotpConf
+	// nil if disabled
+	passkeys *passkeyLogin
+	tokens   *apitoken.Store
+	limiter  *buzzLimiter
 }
 
-func StartWeb(config conf.ServerConf, wikiAuth wikiauth.WikiAuth, mqttHandler *mqtt.MqttHandler) {
-	webHandler := web{wikiAuth, mqttHandler}
+func StartWeb(tomlConfig conf.TomlConfig, wikiAuth wikiauth.WikiAuth, mqttHandler *mqtt.MqttHandler) {
+	config := tomlConfig.Server
+
+	doors := mqttHandler.Doors()
+	permissions, err := access.NewPermissions(tomlConfig.Permissions, doorIds(doors))
+	if err != nil {
+		logger.WithError(err).Fatal("Invalid permissions config.")
+	}
+	auditLog, err := audit.NewLog(tomlConfig.Audit)
+	if err != nil {
+		logger.WithError(err).Fatal("Can't create the audit log.")
+	}
+	if auditLog == nil && (len(tomlConfig.Admin.Users) > 0 || len(tomlConfig.Admin.Groups) > 0) {
+		logger.Fatal("The admin page needs the audit log, please set the 'directory' in the [Audit] section.")
+	}
+
+	webHandler := web{
+		wikiData:      wikiAuth,
+		mqttHandler:   mqttHandler,
+		doors:         doors,
+		loginThrottle: newLoginThrottle(config),
+		oidc:          newOidcLogin(tomlConfig.Oidc),
+		permissions:   permissions,
+		auditLog:      auditLog,
+		adminConf:     tomlConfig.Admin,
+		// the membership of the session users is checked again from time to time
+		membershipCheck: newMembershipCheck(wikiAuth, config),
+		totp:            newTotpStore(tomlConfig.Totp, config.KeysFile),
+		totpConf:        tomlConfig.Totp,
+		passkeys:        newPasskeyLogin(tomlConfig.Webauthn, config.KeysFile),
+		tokens:          newTokenStore(config),
+		limiter:         newBuzzLimiter(tomlConfig.BuzzLimit),
+	}
 
 	keys := conf.GetKeys(config.KeysFile)
+	sessionsFile := dataFile(config.SessionsFile, config.KeysFile, DEFAULT_SESSIONS_FILE)
+	webHandler.sessionStore = sessionstore.NewStore(sessionsFile, KEY_USER_NAME, keys.SessionKeyPairs()...)
 
 	gin.DisableConsoleColor()
 	gin.DefaultWriter = logrus.WithField("where", "gin").WriterLevel(logrus.DebugLevel)
@@ -35,18 +117,17 @@ func StartWeb(config conf.ServerConf, wikiAuth wikiauth.WikiAuth, mqttHandler *m
 
 	router := gin.Default()
 
-	store := cookie.NewStore(keys.SessionAuthKey, keys.SessionEncryptionKey)
-	store.Options(sessions.Options{HttpOnly: true, Secure: true})
-	router.Use(sessions.Sessions("sesam", store))
+	webHandler.sessionStore.Options(sessionOptions)
+	router.Use(sessions.Sessions(SESSION_NAME, webHandler.sessionStore))
 
-	router.Use(csrf.Middleware(csrf.Options{
-		Secret: keys.CsrfKey,
+	router.Use(csrfUnlessApi(csrf.Middleware(csrf.Options{
+		Secret: keys.Current().CsrfKey,
 		ErrorFunc: func(c *gin.Context) {
 			logger.Warn("CSRF token mismatch")
 			c.String(400, "CSRF token mismatch")
 			c.Abort()
 		},
-	}))
+	})))
 
 	router.Static("/assets", "webUI/assets")
 	router.StaticFile("/swDummy.js", "webUI/swDummy.js")
@@ -57,10 +138,41 @@ func StartWeb(config conf.ServerConf, wikiAuth wikiauth.WikiAuth, mqttHandler *m
 
 	router.GET("/login", webHandler.getLogin)
 	router.POST("/login", webHandler.postLogin)
+	router.GET("/login/totp", webHandler.getLoginTotp)
+	router.POST("/login/totp", webHandler.postLoginTotp)
 	router.GET("/logout", webHandler.getLogout)
+	router.GET("/totp", webHandler.getTotp)
+	router.POST("/totp", webHandler.postTotp)
+	router.POST("/totp/remove", webHandler.postTotpRemove)
+	router.GET("/sessions", webHandler.getSessions)
+	router.POST("/sessions/revoke", webHandler.postRevokeSession)
+	router.GET("/tokens", webHandler.getTokens)
+	router.POST("/tokens", webHandler.postTokens)
+	router.POST("/tokens/revoke", webHandler.postRevokeToken)
+	router.POST("/prefs", webHandler.postPrefs)
+	router.PUT("/api/token/buzzer", webHandler.putTokenBuzzer)
+	router.POST("/api/token/buzzer", webHandler.putTokenBuzzer)
+	addApiRoutes(router, &webHandler)
+	router.GET("/health", webHandler.getHealth)
+	if webHandler.oidc != nil {
+		router.GET("/login/oidc", webHandler.getOidcLogin)
+		router.GET("/login/oidc/callback", webHandler.getOidcCallback)
+	}
+	if webHandler.passkeys != nil {
+		router.POST("/login/passkey/begin", webHandler.postPasskeyLoginBegin)
+		router.POST("/login/passkey/finish", webHandler.postPasskeyLoginFinish)
+		router.GET("/passkeys", webHandler.getPasskeys)
+		router.POST("/passkeys/register/begin", webHandler.postPasskeyRegisterBegin)
+		router.POST("/passkeys/register/finish", webHandler.postPasskeyRegisterFinish)
+		router.POST("/passkeys/remove", webHandler.postPasskeyRemove)
+	}
+
+	admin := router.Group("/admin", webHandler.requireAdmin)
+	admin.GET("", webHandler.getAdmin)
+	admin.GET("/audit.csv", webHandler.getAdminAuditCsv)
+	admin.GET("/sessions", webHandler.getAdminSessions)
 
 	addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	var err error
 	if config.Https {
 		err = router.RunTLS(addr, config.CertFile, config.CertKeyFile)
 	} else {
@@ -72,16 +184,14 @@ func StartWeb(config conf.ServerConf, wikiAuth wikiauth.WikiAuth, mqttHandler *m
 }
 
 func (w *web) getMain(c *gin.Context) {
-	session := sessions.Default(c)
-	loginV := session.Get(KEY_USER_NAME)
-	if loginV == nil {
+	login, ok := w.sessionUser(c)
+	if !ok {
 		logger.Info("Not logged in.")
 		c.Redirect(http.StatusSeeOther, "/login")
 		return
 	}
-	login := loginV.(string)
 
-	isOpen := isOpenForMember(w.mqttHandler.CurrentStatus())
+	isOpen := w.mqttHandler.IsAllowed(mqtt.ActionBuzz)
 	var status string
 	if isOpen {
 		status = "opened"
@@ -91,53 +201,164 @@ func (w *web) getMain(c *gin.Context) {
 	c.HTML(http.StatusOK, "index.html", gin.H{
 		"login":       login,
 		"statusClass": status,
-		"isOpen":      isOpen,
+		"doors":       w.doorViews(login),
+		"totpMissing": w.totpMissing(login),
+		"passkeys":    w.passkeys != nil,
+		"isAdmin":     w.isAdmin(login),
 		"csrf":        csrf.GetToken(c),
+		"events":      w.mqttHandler.PublishesEvents(),
+		"shareName":   sessions.Default(c).Get(KEY_SHARE_NAME) == true,
 	})
 }
 
 func (w *web) putBuzzer(c *gin.Context) {
 	ipLogger := logger.WithField("ip", c.ClientIP())
-	session := sessions.Default(c)
-	loginV := session.Get(KEY_USER_NAME)
-	if loginV == nil {
+	userName, ok := w.sessionUser(c)
+	if !ok {
 		ipLogger.Info("Not logged in.")
 		c.String(200, "LOGIN")
 		return
 	}
-	userName := loginV.(string)
-
-	doorStr := c.Query("door")
-	var door mqtt.Door
-	switch doorStr {
-	case "innerGlass":
-		door = mqtt.DoorInnerGlass
-	case "innerMetal":
-		door = mqtt.DoorInnerMetal
-	case "outer":
-		door = mqtt.DoorOuter
+
+	err := w.buzz(c, userName, c.Query("door"), "")
+	switch denied := err.(type) {
+	case nil:
+		c.String(200, "OK")
+	case *buzzDenied:
+		if denied.retryAfter > 0 {
+			setRetryAfter(c, denied.retryAfter)
+		}
+		c.String(denied.httpStatus, denied.message)
+	case *buzzFailed:
+		c.String(denied.httpStatus(), denied.message())
 	default:
+		c.String(200, "ERROR")
+	}
+}
+
+// buzzFailed is returned by buzz, if the buzzer message was not sent or not confirmed by the door controller
+type buzzFailed struct {
+	result mqtt.BuzzResult
+}
+
+func (f *buzzFailed) Error() string {
+	return string(f.result)
+}
+
+func (f *buzzFailed) httpStatus() int {
+	switch f.result {
+	case mqtt.BuzzNotAllowed:
+		return http.StatusForbidden
+	case mqtt.BuzzOffline:
+		return http.StatusServiceUnavailable
+	case mqtt.BuzzTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func (f *buzzFailed) message() string {
+	switch f.result {
+	case mqtt.BuzzNotAllowed:
+		return "Sorry, this door can't be opened with the current space status."
+	case mqtt.BuzzOffline:
+		return "Sorry, the door controller is offline."
+	case mqtt.BuzzTimeout:
+		return "The door didn't confirm the buzzer, please check if it's open."
+	default:
+		return "The door controller reported an error, please try again."
+	}
+}
+
+// buzzDenied is returned by buzz, if the user can't open the door
+type buzzDenied struct {
+	httpStatus int
+	message    string
+	// set if the user can try again later
+	retryAfter time.Duration
+}
+
+func (d *buzzDenied) Error() string {
+	return d.message
+}
+
+// buzz opens the door if the user is allowed to. The detail is added to the audit log, e.g. the API token. It returns
+// a *buzzDenied or *buzzFailed if the door was not opened.
+func (w *web) buzz(c *gin.Context, userName string, doorStr string, detail string) error {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	door, found := w.findDoor(doorStr)
+	if !found {
 		ipLogger.WithField("doorStr", doorStr).Error("Invalid 'door' param")
-		sendError(c, "Invalid 'door' param.")
-		return
+		return &buzzDenied{httpStatus: http.StatusBadRequest, message: "Error: Invalid 'door' param."}
+	}
+
+	entry := audit.Entry{Kind: audit.KindBuzz, User: userName, Door: door.Id, Detail: detail}
+	deny := func(reason string, httpStatus int, message string) error {
+		entry.Result = audit.ResultDenied
+		entry.Detail = joinDetail(detail, reason)
+		w.audit(c, entry)
+		return &buzzDenied{httpStatus: httpStatus, message: message}
+	}
+	if w.totpMissing(userName) {
+		ipLogger.WithField("userName", userName).Warn("second factor is missing")
+		return deny("no second factor", http.StatusForbidden, "Sorry, you need to set up the second factor (TOTP) first.")
+	}
+	if !w.permissions.MayOpen(userName, door.Id) {
+		ipLogger.WithField("userName", userName).WithField("door", doorStr).Warn("door not allowed for the user")
+		return deny("not granted", http.StatusForbidden, "Sorry, you are not allowed to open this door.")
+	}
+	if now := time.Now(); !w.permissions.MayOpenAt(userName, door.Id, now) {
+		ipLogger.WithField("userName", userName).WithField("door", doorStr).Warn("door not allowed at this time")
+		return deny("outside of the schedule", http.StatusForbidden,
+			"Sorry, you can't open this door now. Next time: "+w.nextAllowedText(userName, door.Id, now))
 	}
 
-	ok := w.mqttHandler.SendDoorBuzzer(door)
-	//ok := true;
+	if !w.mqttHandler.MayBuzz(door) {
+		status := w.mqttHandler.CurrentStatus().State
+		ipLogger.WithField("userName", userName).WithField("door", doorStr).WithField("status", status).Warn("door not allowed for the space status")
+		return deny("space status "+string(status), http.StatusForbidden, "Sorry, this door can't be opened with the current space status.")
+	}
+
+	if wait, reason := w.limiter.take(userName, door); wait > 0 {
+		ipLogger.WithField("userName", userName).WithField("door", doorStr).WithField("wait", wait).Warn("door buzzer limited: " + string(reason))
+		entry.Result = audit.ResultDenied
+		entry.Detail = joinDetail(detail, string(reason))
+		w.audit(c, entry)
+		message := "Please wait a moment, the door was just opened."
+		if reason == limitQuota {
+			message = "Sorry, you opened the doors too often, please wait a while."
+		}
+		return &buzzDenied{httpStatus: http.StatusTooManyRequests, message: message, retryAfter: wait}
+	}
+
+	result := w.mqttHandler.SendDoorBuzzer(door)
+	//result := mqtt.BuzzOk;
 	//println(door)
-	if ok {
-		ipLogger.WithField("userName", userName).WithField("door", doorStr).Info("door opened")
-		c.String(200, "OK")
-	} else {
-		c.String(200, "ERROR")
+	if result != mqtt.BuzzOk {
+		ipLogger.WithField("userName", userName).WithField("door", doorStr).WithField("result", result).Warn("door not opened")
+		entry.Result = audit.ResultError
+		entry.Detail = joinDetail(detail, string(result))
+		w.audit(c, entry)
+		return &buzzFailed{result}
 	}
+	ipLogger.WithField("userName", userName).WithField("door", doorStr).Info("door opened")
+	entry.Result = audit.ResultOk
+	w.audit(c, entry)
+	w.mqttHandler.PublishBuzzEvent(door, w.eventName(c, userName))
+	return nil
+}
+
+// joinDetail combines the details of an audit entry
+func joinDetail(detail string, more string) string {
+	if detail == "" {
+		return more
+	}
+	return detail + ", " + more
 }
 
 func (w *web) getLogin(c *gin.Context) {
-	c.HTML(http.StatusOK, "login.html", gin.H{
-		"days": REMEMBER_PASSWORD_DAYS,
-		"csrf": csrf.GetToken(c),
-	})
+	w.renderLogin(c, http.StatusOK, gin.H{})
 }
 
 func (w *web) postLogin(c *gin.Context) {
@@ -149,31 +370,96 @@ func (w *web) postLogin(c *gin.Context) {
 		return
 	}
 
-	// just let this request take at least one second to make password guessing more difficult.
-	time.Sleep(time.Duration(time.Second))
-
-	userName, authErr := w.wikiData.CheckPassword(form.Email, form.Password)
+	userName, blockedFor, authErr := w.passwordLogin(c, form.Email, form.Password)
+	if authErr == nil && blockedFor > 0 {
+		w.sendTooManyAttempts(c, blockedFor)
+		return
+	}
 	if authErr != nil {
-		ipLogger.WithField("login", form.Email).WithField("system", authErr.SystemError).WithError(authErr.Error).Warn("login failed.")
-		c.HTML(http.StatusOK, "login.html", gin.H{
-			"days":        REMEMBER_PASSWORD_DAYS,
+		// only show the ban directly, the short backoff times are shown with the next try
+		if blockedFor >= time.Minute {
+			w.sendTooManyAttempts(c, blockedFor)
+			return
+		}
+		w.renderLogin(c, http.StatusOK, gin.H{
 			"error":       !authErr.SystemError,
 			"systemError": authErr.SystemError,
-			"csrf":        csrf.GetToken(c),
 		})
 		return
 	}
 
-	ipLogger.WithField("userName", userName).Info("login successful")
+	w.finishLogin(c, userName, len(form.Remember) > 0, loginMethodPassword, false)
+}
+
+// passwordLogin checks the password with the auth backend, the failures are throttled and added to the audit log. If
+// the client or login is blocked, the password is not checked and only blockedFor is returned. After a failed check,
+// blockedFor is the block caused by this failure.
+func (w *web) passwordLogin(c *gin.Context, login string, password string) (string, time.Duration, *wikiauth.AuthError) {
+	ipLogger := logger.WithField("ip", c.ClientIP())
+	if blockedFor := w.loginThrottle.blockedFor(c.ClientIP(), login); blockedFor > 0 {
+		ipLogger.WithField("login", login).WithField("blockedFor", blockedFor).Warn("login blocked.")
+		w.audit(c, audit.Entry{Kind: audit.KindLogin, User: login, Result: audit.ResultDenied, Detail: "too many attempts"})
+		return "", blockedFor, nil
+	}
+
+	// just let this request take at least one second to make password guessing more difficult.
+	time.Sleep(time.Duration(time.Second))
+
+	userName, authErr := w.wikiData.CheckPassword(login, password)
+	if authErr != nil {
+		ipLogger.WithField("login", login).WithField("system", authErr.SystemError).WithError(authErr.Error).Warn("login failed.")
+		entry := audit.Entry{Kind: audit.KindLogin, User: login, Result: audit.ResultFailed, Detail: authErr.Error.Error()}
+		if authErr.SystemError {
+			entry.Result = audit.ResultError
+		}
+		w.audit(c, entry)
+		if authErr.SystemError {
+			return "", 0, authErr
+		}
+		w.loginThrottle.addFailure(c.ClientIP(), login)
+		return "", w.loginThrottle.blockedFor(c.ClientIP(), login), authErr
+	}
+
+	w.loginThrottle.reset(login)
+	return userName, 0, nil
+}
+
+// finishLogin is called after the password or SSO check. Users with a second factor must enter a code first, the
+// session starts afterwards.
+func (w *web) finishLogin(c *gin.Context, userName string, remember bool, method string, oidcLogin bool) {
+	if !w.totp.IsEnrolled(userName) {
+		w.startSession(c, userName, remember, method, oidcLogin)
+		c.Redirect(http.StatusSeeOther, "/")
+		return
+	}
+
 	session := sessions.Default(c)
-	if len(form.Remember) > 0 {
-		maxAgeSeconds := REMEMBER_PASSWORD_DAYS * 24 * 60 * 60
-		session.Options(sessions.Options{MaxAge: maxAgeSeconds, HttpOnly: true, Secure: true})
+	session.Set(KEY_TOTP_PENDING_USER, userName)
+	session.Set(KEY_TOTP_PENDING_SINCE, time.Now().Unix())
+	session.Set(KEY_TOTP_PENDING_REMEMBER, remember)
+	session.Set(KEY_TOTP_PENDING_METHOD, method)
+	session.Set(KEY_TOTP_PENDING_OIDC, oidcLogin)
+	session.Save()
+
+	c.Redirect(http.StatusSeeOther, "/login/totp")
+}
+
+// startSession logs the user in. The method is shown in the audit log. The sessions of an OIDC login end after the
+// session minutes of the provider.
+func (w *web) startSession(c *gin.Context, userName string, remember bool, method string, oidcLogin bool) {
+	logger.WithField("ip", c.ClientIP()).WithField("userName", userName).WithField("method", method).Info("login successful")
+	w.audit(c, audit.Entry{Kind: audit.KindLogin, User: userName, Result: audit.ResultOk, Detail: method})
+	session := sessions.Default(c)
+	options := sessionOptions
+	if remember {
+		options.MaxAge = REMEMBER_PASSWORD_DAYS * 24 * 60 * 60
 	}
+	session.Options(options)
 	session.Set(KEY_USER_NAME, userName)
+	if oidcLogin {
+		session.Set(KEY_OIDC_LOGIN, time.Now().Unix())
+	}
 	session.Save()
-
-	c.Redirect(http.StatusSeeOther, "/")
 }
 
 func (w *web) getLogout(c *gin.Context) {
@@ -185,16 +471,42 @@ func (w *web) getLogout(c *gin.Context) {
 	c.Redirect(http.StatusSeeOther, "/login")
 }
 
+// renderLogin shows the login page with the given (error) data
+func (w *web) renderLogin(c *gin.Context, httpStatus int, data gin.H) {
+	data["days"] = REMEMBER_PASSWORD_DAYS
+	data["oidc"] = w.oidc != nil
+	data["passkeys"] = w.passkeys != nil
+	data["csrf"] = csrf.GetToken(c)
+	c.HTML(httpStatus, "login.html", data)
+}
+
+func (w *web) sendTooManyAttempts(c *gin.Context, blockedFor time.Duration) {
+	// round up, a block of less than one minute is shown as "a few seconds"
+	minutes := int(blockedFor / time.Minute)
+	if blockedFor%time.Minute > 0 && minutes > 0 {
+		minutes++
+	}
+	w.renderLogin(c, http.StatusTooManyRequests, gin.H{
+		"tooManyAttempts": true,
+		"retryMinutes":    minutes,
+	})
+}
+
+// audit adds the entry with the client ip and space status to the audit log
+func (w *web) audit(c *gin.Context, entry audit.Entry) {
+	if w.auditLog == nil {
+		return
+	}
+	entry.Ip = c.ClientIP()
+	entry.SpaceStatus = string(w.mqttHandler.CurrentStatus().State)
+	w.auditLog.Add(entry)
+}
+
 func sendError(c *gin.Context, msg string) {
 	c.String(http.StatusBadRequest, "Error: "+msg)
 	c.Abort()
 }
 
-// isOpenForMember returns true if the given textual status represents an open statue for normal member.
-func isOpenForMember(mqttStatus string) bool {
-	return mqttStatus == "open+" || mqttStatus == "open" || mqttStatus == "member"
-}
-
 type loginData struct {
 	Email    string `form:"email" binding:"required"`
 	Password string `form:"password" binding:"required"`
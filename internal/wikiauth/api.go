@@ -9,3 +9,10 @@ type AuthError struct {
 type WikiAuth interface {
 	CheckPassword(emailOrName string, password string) (userName string, errResult *AuthError)
 }
+
+// MembershipChecker is implemented by backends that can check the membership without the password. It's used to
+// revalidate the users of existing sessions.
+type MembershipChecker interface {
+	// IsMember returns false if the user is not (or no longer) a member. An error means the backend couldn't answer.
+	IsMember(userName string) (isMember bool, err error)
+}
@@ -0,0 +1,72 @@
+package wikiauth
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+)
+
+// the backend used if the config doesn't choose one
+const defaultBackend = "online"
+
+// the offline cache of the online backend is stored next to the keys file, if no other file is configured
+const defaultOfflineCacheFile = "offlineLogins.json"
+
+// backendFactory validates the config section of a backend and creates the backend
+type backendFactory func(config *conf.TomlConfig) (WikiAuth, error)
+
+var backends = map[string]backendFactory{
+	"online": newOnlineAuthBackend,
+	"local":  newLocalFilesAuthBackend,
+	"ldap":   newLdapAuthBackend,
+}
+
+// NewWikiAuth creates the auth backend chosen with 'backend' in the [Auth] section.
+func NewWikiAuth(config *conf.TomlConfig) (WikiAuth, error) {
+	name := BackendName(config.Auth)
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown auth backend '%s', valid are: %s", name, strings.Join(backendNames(), ", "))
+	}
+
+	return factory(config)
+}
+
+// BackendName returns the configured backend name or the default.
+func BackendName(config conf.AuthConf) string {
+	if config.Backend == "" {
+		return defaultBackend
+	}
+	return config.Backend
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newOnlineAuthBackend(config *conf.TomlConfig) (WikiAuth, error) {
+	if config.AuthOnline.WikiBaseUrl == "" || config.AuthOnline.AuthToken == "" {
+		return nil, errors.New("the [AuthOnline] section needs 'wikiBaseUrl' and 'authToken'")
+	}
+	cacheFile := config.AuthOnline.OfflineCacheFile
+	if cacheFile == "" {
+		cacheFile = filepath.Join(filepath.Dir(config.Server.KeysFile), defaultOfflineCacheFile)
+	}
+	return NewOnlineAuth(&config.AuthOnline, cacheFile), nil
+}
+
+func newLocalFilesAuthBackend(config *conf.TomlConfig) (WikiAuth, error) {
+	if config.AuthLocal.UserDirectory == "" || config.AuthLocal.GroupPageFile == "" {
+		return nil, errors.New("the [AuthLocal] section needs 'userDirectory' and 'groupPageFile'")
+	}
+	return NewLocalFilesAuth(&config.AuthLocal), nil
+}
@@ -0,0 +1,69 @@
+package wikiauth
+
+import (
+	"testing"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_BackendName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("online", BackendName(conf.AuthConf{}))
+	assert.Equal("ldap", BackendName(conf.AuthConf{Backend: "ldap"}))
+}
+
+func Test_NewWikiAuth(t *testing.T) {
+	online := conf.AuthOnline{WikiBaseUrl: "https://wiki.example.org", AuthToken: "token"}
+	ldap := conf.AuthLdap{Url: "ldap://ldap.example.org", BaseDn: "dc=example,dc=org",
+		GroupDn: "cn=members,dc=example,dc=org"}
+
+	tests := []struct {
+		name   string
+		config conf.TomlConfig
+		// the type of the backend, nil if an error is expected
+		expected WikiAuth
+		err      string
+	}{
+		{"default backend", conf.TomlConfig{AuthOnline: online}, &onlineAuth{}, ""},
+		{"online", conf.TomlConfig{Auth: conf.AuthConf{Backend: "online"}, AuthOnline: online}, &onlineAuth{}, ""},
+		{"ldap", conf.TomlConfig{Auth: conf.AuthConf{Backend: "ldap"}, AuthLdap: ldap}, &ldapAuth{}, ""},
+		{"chain", conf.TomlConfig{Auth: conf.AuthConf{Backend: "chain", Chain: []string{"online", "ldap"}},
+			AuthOnline: online, AuthLdap: ldap}, &chainAuth{}, ""},
+
+		{"unknown backend", conf.TomlConfig{Auth: conf.AuthConf{Backend: "wiki"}}, nil,
+			"unknown auth backend 'wiki', valid are: chain, ldap, local, online"},
+		{"default backend without section", conf.TomlConfig{}, nil, "the [AuthOnline] section needs"},
+		{"online without token", conf.TomlConfig{Auth: conf.AuthConf{Backend: "online"},
+			AuthOnline: conf.AuthOnline{WikiBaseUrl: "https://wiki.example.org"}}, nil, "the [AuthOnline] section needs"},
+		{"local without section", conf.TomlConfig{Auth: conf.AuthConf{Backend: "local"}}, nil,
+			"the [AuthLocal] section needs"},
+		{"ldap without section", conf.TomlConfig{Auth: conf.AuthConf{Backend: "ldap"}}, nil,
+			"the [AuthLdap] section needs"},
+		{"chain without backends", conf.TomlConfig{Auth: conf.AuthConf{Backend: "chain"}}, nil,
+			"the [Auth] section needs 'chain'"},
+		{"chain without section of a backend", conf.TomlConfig{Auth: conf.AuthConf{Backend: "chain",
+			Chain: []string{"online", "ldap"}}, AuthOnline: online}, nil, "the [AuthLdap] section needs"},
+		{"chain with unknown backend", conf.TomlConfig{Auth: conf.AuthConf{Backend: "chain", Chain: []string{"wiki"}}},
+			nil, "unknown auth backend 'wiki' in the chain"},
+		{"chain in chain", conf.TomlConfig{Auth: conf.AuthConf{Backend: "chain", Chain: []string{"chain"}}}, nil,
+			"the backend 'chain' can't be part of the chain"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			config := test.config
+			auth, err := NewWikiAuth(&config)
+			if test.expected == nil {
+				assert.Nil(auth)
+				if assert.Error(err) {
+					assert.Contains(err.Error(), test.err)
+				}
+				return
+			}
+			assert.NoError(err)
+			assert.IsType(test.expected, auth)
+		})
+	}
+}
@@ -2,6 +2,8 @@ package wikiauth
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"github.com/ktt-ol/sesam/internal/conf"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/hlandau/passlib.v1"
@@ -20,10 +22,11 @@ type localFilesAuth struct {
 	nameToHashMap map[string]string
 	// email address -> name
 	emailToNameMap map[string]string
+	groupPageFile  string
 }
 
 func NewLocalFilesAuth(config *conf.AuthLocal) WikiAuth {
-	wd := localFilesAuth{make(map[string]string), make(map[string]string)}
+	wd := localFilesAuth{make(map[string]string), make(map[string]string), config.GroupPageFile}
 	memberNames := wd.loadGroupData(config.GroupPageFile)
 	wd.loadUserDataDir(config.UserDirectory, memberNames)
 	return &wd
@@ -36,8 +39,7 @@ func (w *localFilesAuth) CheckPassword(emailOrName string, password string) (use
 	if strings.Contains(emailOrName, "@") {
 		nameFromMap, ok := w.emailToNameMap[emailOrName]
 		if !ok {
-			//errResult = errors.New("email doesn't exist (or not in Member group)")
-			//loginNotFound = true
+			authError = &AuthError{Error: errors.New("email doesn't exist (or not in Member group)"), LoginNotFound: true}
 			return
 		}
 		userName = nameFromMap
@@ -45,8 +47,7 @@ func (w *localFilesAuth) CheckPassword(emailOrName string, password string) (use
 
 	pwHash, ok := w.nameToHashMap[userName]
 	if !ok {
-		//errResult = errors.New("user doesn't exist (or not in Member group)")
-		//loginNotFound = true
+		authError = &AuthError{Error: errors.New("user doesn't exist (or not in Member group)"), LoginNotFound: true}
 		return
 	}
 
@@ -54,20 +55,40 @@ func (w *localFilesAuth) CheckPassword(emailOrName string, password string) (use
 	if err != nil {
 		// incorrect password, malformed hash, etc.
 		// either way, reject
-		//errResult = errors.New(fmt.Sprintf("invalid password (lib said: '%s'", err))
+		authError = &AuthError{Error: fmt.Errorf("invalid password (lib said: '%s')", err)}
 		return
 	}
 
 	return
 }
 
+// IsMember reads the group file again, so removed members are noticed without a restart
+func (w *localFilesAuth) IsMember(userName string) (bool, error) {
+	memberNames, err := ReadGroupPage(w.groupPageFile)
+	if err != nil {
+		return false, err
+	}
+	_, isMember := memberNames[userName]
+	return isMember, nil
+}
+
 func (w *localFilesAuth) loadGroupData(groupPageFile string) map[string]struct{} {
-	file, err := os.Open(groupPageFile)
-	defer file.Close()
+	memberNameSet, err := ReadGroupPage(groupPageFile)
 	if err != nil {
 		logger.WithError(err).WithField("groupPageFile", groupPageFile).Fatal("Can't open group file")
 	}
 
+	return memberNameSet
+}
+
+// ReadGroupPage returns the names of a wiki group page. Every line like " * name" is a member.
+func ReadGroupPage(groupPageFile string) (map[string]struct{}, error) {
+	file, err := os.Open(groupPageFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
 	scanner := bufio.NewScanner(file)
 	scanner.Split(bufio.ScanLines)
 
@@ -82,7 +103,7 @@ func (w *localFilesAuth) loadGroupData(groupPageFile string) map[string]struct{}
 		memberNameSet[match[1]] = struct{}{}
 	}
 
-	return memberNameSet
+	return memberNameSet, scanner.Err()
 }
 
 func (w *localFilesAuth) loadUserDataDir(userDirectory string, memberNames map[string]struct{}) {
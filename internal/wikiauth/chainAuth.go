@@ -0,0 +1,82 @@
+package wikiauth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/sirupsen/logrus"
+)
+
+type namedAuth struct {
+	name string
+	auth WikiAuth
+}
+
+// chainAuth asks the backends in order. The next backend is only asked, if the previous had a system error (e.g. the
+// wiki is down). A wrong password or an unknown login is the final answer.
+type chainAuth struct {
+	log      *logrus.Entry
+	backends []namedAuth
+}
+
+func init() {
+	// registered here, because the chain itself uses the backend map
+	backends["chain"] = newChainAuthBackend
+}
+
+func newChainAuthBackend(config *conf.TomlConfig) (WikiAuth, error) {
+	if len(config.Auth.Chain) == 0 {
+		return nil, errors.New("the [Auth] section needs 'chain' for the backend 'chain'")
+	}
+
+	auth := chainAuth{log: logrus.WithField("where", "chainAuth")}
+	for _, name := range config.Auth.Chain {
+		if name == "chain" {
+			return nil, errors.New("the backend 'chain' can't be part of the chain")
+		}
+		factory, ok := backends[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown auth backend '%s' in the chain", name)
+		}
+		backend, err := factory(config)
+		if err != nil {
+			return nil, err
+		}
+		auth.backends = append(auth.backends, namedAuth{name, backend})
+	}
+
+	return &auth, nil
+}
+
+func (a *chainAuth) CheckPassword(emailOrName string, password string) (userName string, authError *AuthError) {
+	for _, backend := range a.backends {
+		userName, authError = backend.auth.CheckPassword(emailOrName, password)
+		if authError == nil || !authError.SystemError {
+			a.log.WithField("backend", backend.name).WithField("success", authError == nil).Info("backend answered")
+			return
+		}
+		a.log.WithField("backend", backend.name).WithError(authError.Error).Warn("backend failed, trying the next one")
+	}
+
+	return
+}
+
+// IsMember asks the backends that can check the membership in order, the first answer counts
+func (a *chainAuth) IsMember(userName string) (bool, error) {
+	err := errors.New("no backend of the chain can check the membership")
+	for _, backend := range a.backends {
+		checker, ok := backend.auth.(MembershipChecker)
+		if !ok {
+			continue
+		}
+		var isMember bool
+		isMember, err = checker.IsMember(userName)
+		if err == nil {
+			return isMember, nil
+		}
+		a.log.WithField("backend", backend.name).WithError(err).Warn("membership check failed, trying the next one")
+	}
+
+	return false, err
+}
@@ -0,0 +1,106 @@
+package wikiauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuth struct {
+	userName  string
+	authError *AuthError
+	calls     int
+}
+
+func (f *fakeAuth) CheckPassword(emailOrName string, password string) (string, *AuthError) {
+	f.calls++
+	return f.userName, f.authError
+}
+
+// fakeMemberAuth can check the membership, too
+type fakeMemberAuth struct {
+	fakeAuth
+	isMember  bool
+	memberErr error
+}
+
+func (f *fakeMemberAuth) IsMember(userName string) (bool, error) {
+	f.calls++
+	return f.isMember, f.memberErr
+}
+
+func newTestChain(backends ...WikiAuth) *chainAuth {
+	chain := chainAuth{log: logrus.WithField("where", "test")}
+	for _, backend := range backends {
+		chain.backends = append(chain.backends, namedAuth{"fake", backend})
+	}
+	return &chain
+}
+
+func Test_chainAuth_fallbackOnSystemError(t *testing.T) {
+	assert := assert.New(t)
+
+	first := &fakeAuth{authError: &AuthError{Error: errors.New("down"), SystemError: true}}
+	second := &fakeAuth{userName: "alice"}
+	chain := newTestChain(first, second)
+
+	userName, authErr := chain.CheckPassword("alice", "secret")
+	assert.Nil(authErr)
+	assert.Equal("alice", userName)
+	assert.Equal(1, first.calls)
+	assert.Equal(1, second.calls)
+}
+
+func Test_chainAuth_noFallbackOnWrongPassword(t *testing.T) {
+	assert := assert.New(t)
+
+	first := &fakeAuth{userName: "alice", authError: &AuthError{Error: errors.New("Invalid password")}}
+	second := &fakeAuth{userName: "alice"}
+	chain := newTestChain(first, second)
+
+	_, authErr := chain.CheckPassword("alice", "wrong")
+	assert.NotNil(authErr)
+	assert.False(authErr.SystemError)
+	assert.Equal(0, second.calls)
+}
+
+func Test_chainAuth_allDown(t *testing.T) {
+	assert := assert.New(t)
+
+	first := &fakeAuth{authError: &AuthError{Error: errors.New("down"), SystemError: true}}
+	second := &fakeAuth{authError: &AuthError{Error: errors.New("also down"), SystemError: true}}
+	chain := newTestChain(first, second)
+
+	_, authErr := chain.CheckPassword("alice", "secret")
+	assert.NotNil(authErr)
+	assert.True(authErr.SystemError)
+	assert.Equal("also down", authErr.Error.Error())
+}
+
+func Test_chainAuth_isMember(t *testing.T) {
+	assert := assert.New(t)
+
+	noChecker := &fakeAuth{}
+	down := &fakeMemberAuth{memberErr: errors.New("down")}
+	notMember := &fakeMemberAuth{isMember: false}
+	member := &fakeMemberAuth{isMember: true}
+
+	isMember, err := newTestChain(noChecker, down, notMember, member).IsMember("alice")
+	assert.NoError(err)
+	assert.False(isMember)
+	assert.Equal(0, noChecker.calls)
+	assert.Equal(1, down.calls)
+	assert.Equal(0, member.calls)
+
+	isMember, err = newTestChain(down, member).IsMember("alice")
+	assert.NoError(err)
+	assert.True(isMember)
+
+	_, err = newTestChain(noChecker, down).IsMember("alice")
+	assert.Equal("down", err.Error())
+
+	_, err = newTestChain(noChecker).IsMember("alice")
+	assert.Error(err)
+}
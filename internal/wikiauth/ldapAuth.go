@@ -0,0 +1,204 @@
+package wikiauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/ldap.v3"
+)
+
+const ldapTimeout = 10 * time.Second
+
+// defaults, used if the config doesn't set a value
+const defaultLdapUserFilter = "(objectClass=inetOrgPerson)"
+const defaultLdapUidAttribute = "uid"
+const defaultLdapMailAttribute = "mail"
+const defaultLdapGroupMemberAttribute = "member"
+
+// ldapConn contains the used functions of ldap.Conn, tests use a stand-in
+type ldapConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close()
+}
+
+// ldapAuth searches the user by email or uid, binds with the user's password and checks that the user is in the
+// member group.
+type ldapAuth struct {
+	log    *logrus.Entry
+	config conf.AuthLdap
+	dial   func() (ldapConn, error)
+}
+
+func newLdapAuthBackend(config *conf.TomlConfig) (WikiAuth, error) {
+	if config.AuthLdap.Url == "" || config.AuthLdap.BaseDn == "" || config.AuthLdap.GroupDn == "" {
+		return nil, errors.New("the [AuthLdap] section needs 'url', 'baseDn' and 'groupDn'")
+	}
+	return NewLdapAuth(&config.AuthLdap), nil
+}
+
+func NewLdapAuth(config *conf.AuthLdap) WikiAuth {
+	auth := ldapAuth{
+		log:    logrus.WithField("where", "ldapAuth"),
+		config: *config,
+	}
+	if auth.config.UserFilter == "" {
+		auth.config.UserFilter = defaultLdapUserFilter
+	}
+	if auth.config.UidAttribute == "" {
+		auth.config.UidAttribute = defaultLdapUidAttribute
+	}
+	if auth.config.MailAttribute == "" {
+		auth.config.MailAttribute = defaultLdapMailAttribute
+	}
+	if auth.config.GroupMemberAttribute == "" {
+		auth.config.GroupMemberAttribute = defaultLdapGroupMemberAttribute
+	}
+	auth.dial = auth.dialServer
+	// the connect timeout, the ldap package has no option for it
+	ldap.DefaultTimeout = ldapTimeout
+
+	return &auth
+}
+
+func (a *ldapAuth) CheckPassword(emailOrName string, password string) (userName string, authError *AuthError) {
+	// the server would accept an empty password as an unauthenticated bind
+	if password == "" {
+		return emailOrName, &AuthError{Error: errors.New("Invalid password")}
+	}
+
+	conn, err := a.dial()
+	if err != nil {
+		return "", &AuthError{Error: err, SystemError: true}
+	}
+	defer conn.Close()
+
+	if err := a.serviceBind(conn); err != nil {
+		return "", &AuthError{Error: fmt.Errorf("service bind failed: %s", err), SystemError: true}
+	}
+
+	userDn, userName, authError := a.findUser(conn, emailOrName)
+	if authError != nil {
+		return
+	}
+
+	if err := conn.Bind(userDn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return userName, &AuthError{Error: errors.New("Invalid password")}
+		}
+		return "", &AuthError{Error: err, SystemError: true}
+	}
+
+	// back to the service account, the user might not be allowed to read the group
+	if err := a.serviceBind(conn); err != nil {
+		return "", &AuthError{Error: fmt.Errorf("service bind failed: %s", err), SystemError: true}
+	}
+
+	isMember, err := a.isGroupMember(conn, userDn)
+	if err != nil {
+		return "", &AuthError{Error: err, SystemError: true}
+	}
+	if !isMember {
+		return userName, &AuthError{Error: errors.New("user is not in the member group"), LoginNotFound: true}
+	}
+
+	return userName, nil
+}
+
+// IsMember searches the user by uid and checks the member group
+func (a *ldapAuth) IsMember(userName string) (bool, error) {
+	conn, err := a.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if err := a.serviceBind(conn); err != nil {
+		return false, fmt.Errorf("service bind failed: %s", err)
+	}
+
+	userDn, _, authError := a.findUser(conn, userName)
+	if authError != nil {
+		if authError.LoginNotFound {
+			return false, nil
+		}
+		return false, authError.Error
+	}
+
+	return a.isGroupMember(conn, userDn)
+}
+
+func (a *ldapAuth) dialServer() (ldapConn, error) {
+	conn, err := ldap.DialURL(a.config.Url)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if a.config.StartTls {
+		serverUrl, err := url.Parse(a.config.Url)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: serverUrl.Hostname()}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// serviceBind binds with the configured service account. Without an account, the searches are done anonymously
+// or with the permissions of the user.
+func (a *ldapAuth) serviceBind(conn ldapConn) error {
+	if a.config.BindDn == "" {
+		return nil
+	}
+	return conn.Bind(a.config.BindDn, a.config.BindPassword)
+}
+
+func (a *ldapAuth) findUser(conn ldapConn, emailOrName string) (userDn string, userName string, authError *AuthError) {
+	attribute := a.config.UidAttribute
+	if strings.Contains(emailOrName, "@") {
+		attribute = a.config.MailAttribute
+	}
+
+	filter := fmt.Sprintf("(&%s(%s=%s))", a.config.UserFilter, attribute, ldap.EscapeFilter(emailOrName))
+	request := ldap.NewSearchRequest(a.config.BaseDn, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		filter, []string{a.config.UidAttribute}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return "", "", &AuthError{Error: err, SystemError: true}
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return "", "", &AuthError{Error: errors.New("login doesn't exist"), LoginNotFound: true}
+	case 1:
+		entry := result.Entries[0]
+		return entry.DN, entry.GetAttributeValue(a.config.UidAttribute), nil
+	default:
+		a.log.WithField("filter", filter).Error("More than one user found.")
+		return "", "", &AuthError{Error: errors.New("login is ambiguous"), SystemError: true}
+	}
+}
+
+func (a *ldapAuth) isGroupMember(conn ldapConn, userDn string) (bool, error) {
+	filter := fmt.Sprintf("(%s=%s)", a.config.GroupMemberAttribute, ldap.EscapeFilter(userDn))
+	request := ldap.NewSearchRequest(a.config.GroupDn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		filter, []string{"dn"}, nil)
+	result, err := conn.Search(request)
+	if err != nil {
+		return false, err
+	}
+
+	return len(result.Entries) > 0, nil
+}
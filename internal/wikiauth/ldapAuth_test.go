@@ -0,0 +1,158 @@
+package wikiauth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v3"
+)
+
+type fakeLdapUser struct {
+	dn       string
+	uid      string
+	mail     string
+	password string
+}
+
+// fakeLdap is an in-process stand-in for the ldap server. It only understands the searches of ldapAuth.
+type fakeLdap struct {
+	users   []fakeLdapUser
+	members map[string]bool
+	down    bool
+	boundDn string
+}
+
+func (f *fakeLdap) Bind(username, password string) error {
+	if username == "cn=sesam" && password == "service" {
+		f.boundDn = username
+		return nil
+	}
+	for _, user := range f.users {
+		if user.dn == username && user.password == password {
+			f.boundDn = username
+			return nil
+		}
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+}
+
+func (f *fakeLdap) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if f.boundDn != "cn=sesam" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, fmt.Errorf("not bound"))
+	}
+
+	result := &ldap.SearchResult{}
+	if request.BaseDN == "cn=members" {
+		for dn := range f.members {
+			if strings.Contains(request.Filter, "(member="+ldap.EscapeFilter(dn)+")") {
+				result.Entries = append(result.Entries, ldap.NewEntry("cn=members", nil))
+			}
+		}
+		return result, nil
+	}
+
+	for _, user := range f.users {
+		if strings.Contains(request.Filter, "(uid="+user.uid+")") || strings.Contains(request.Filter, "(mail="+user.mail+")") {
+			result.Entries = append(result.Entries, ldap.NewEntry(user.dn, map[string][]string{"uid": {user.uid}}))
+		}
+	}
+	return result, nil
+}
+
+func (f *fakeLdap) Close() {
+}
+
+func newTestLdapAuth(server *fakeLdap) *ldapAuth {
+	auth := NewLdapAuth(&conf.AuthLdap{
+		Url:          "ldap://localhost",
+		BindDn:       "cn=sesam",
+		BindPassword: "service",
+		BaseDn:       "ou=people",
+		GroupDn:      "cn=members",
+	}).(*ldapAuth)
+	auth.dial = func() (ldapConn, error) {
+		if server.down {
+			return nil, fmt.Errorf("connection refused")
+		}
+		server.boundDn = ""
+		return server, nil
+	}
+	return auth
+}
+
+func newTestLdapServer() *fakeLdap {
+	return &fakeLdap{
+		users: []fakeLdapUser{
+			{dn: "uid=alice,ou=people", uid: "alice", mail: "alice@example.org", password: "secret"},
+			{dn: "uid=bob,ou=people", uid: "bob", mail: "bob@example.org", password: "secret"},
+		},
+		members: map[string]bool{"uid=alice,ou=people": true},
+	}
+}
+
+func Test_ldapAuth_login(t *testing.T) {
+	assert := assert.New(t)
+	auth := newTestLdapAuth(newTestLdapServer())
+
+	userName, authErr := auth.CheckPassword("alice", "secret")
+	assert.Nil(authErr)
+	assert.Equal("alice", userName)
+
+	userName, authErr = auth.CheckPassword("alice@example.org", "secret")
+	assert.Nil(authErr)
+	assert.Equal("alice", userName)
+}
+
+func Test_ldapAuth_failures(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestLdapServer()
+	auth := newTestLdapAuth(server)
+
+	_, authErr := auth.CheckPassword("alice", "wrong")
+	assert.NotNil(authErr)
+	assert.False(authErr.SystemError)
+	assert.False(authErr.LoginNotFound)
+
+	_, authErr = auth.CheckPassword("alice", "")
+	assert.NotNil(authErr)
+	assert.False(authErr.SystemError)
+
+	_, authErr = auth.CheckPassword("carol", "secret")
+	assert.NotNil(authErr)
+	assert.True(authErr.LoginNotFound)
+
+	// not in the member group
+	_, authErr = auth.CheckPassword("bob", "secret")
+	assert.NotNil(authErr)
+	assert.True(authErr.LoginNotFound)
+
+	server.down = true
+	_, authErr = auth.CheckPassword("alice", "secret")
+	assert.NotNil(authErr)
+	assert.True(authErr.SystemError)
+}
+
+func Test_ldapAuth_isMember(t *testing.T) {
+	assert := assert.New(t)
+	server := newTestLdapServer()
+	auth := newTestLdapAuth(server)
+
+	isMember, err := auth.IsMember("alice")
+	assert.NoError(err)
+	assert.True(isMember)
+
+	isMember, err = auth.IsMember("bob")
+	assert.NoError(err)
+	assert.False(isMember)
+
+	isMember, err = auth.IsMember("carol")
+	assert.NoError(err)
+	assert.False(isMember)
+
+	server.down = true
+	_, err = auth.IsMember("alice")
+	assert.Error(err)
+}
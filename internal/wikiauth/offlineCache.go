@@ -0,0 +1,104 @@
+package wikiauth
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/jsonfile"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/hlandau/passlib.v1"
+)
+
+// offlineCache remembers a salted, slow hash of each successful online login. If the wiki is not reachable, a
+// member can still login with the same password for the grace period after the last successful online login.
+type offlineCache struct {
+	log     *logrus.Entry
+	file    string
+	grace   time.Duration
+	mux     sync.Mutex
+	entries map[string]offlineEntry
+	// replaceable for tests
+	now func() time.Time
+}
+
+type offlineEntry struct {
+	UserName string
+	Hash     string
+	Verified time.Time
+}
+
+func newOfflineCache(file string, grace time.Duration) *offlineCache {
+	cache := offlineCache{
+		log:     logrus.WithField("where", "offlineCache"),
+		file:    file,
+		grace:   grace,
+		entries: make(map[string]offlineEntry),
+		now:     time.Now,
+	}
+
+	if err := jsonfile.Load(file, &cache.entries); err != nil {
+		cache.log.WithError(err).WithField("file", file).Error("Can't read the offline cache, starting with an empty one.")
+		cache.entries = make(map[string]offlineEntry)
+	}
+
+	return &cache
+}
+
+// remember stores the password for the login and the userName after a successful online login. It replaces the
+// entries of the user, also those of another login (e.g. an old email).
+func (c *offlineCache) remember(login string, userName string, password string) {
+	hash, err := passlib.Hash(password)
+	if err != nil {
+		c.log.WithError(err).Error("Can't hash the password.")
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.UserName == userName {
+			delete(c.entries, key)
+		}
+	}
+	entry := offlineEntry{UserName: userName, Hash: hash, Verified: c.now()}
+	c.entries[cacheKey(login)] = entry
+	c.entries[cacheKey(userName)] = entry
+	c.save()
+}
+
+// check returns ok if the password matches the last successful online login within the grace period. cached is
+// false if there is no such login.
+func (c *offlineCache) check(login string, password string) (userName string, cached bool, ok bool) {
+	c.mux.Lock()
+	entry, found := c.entries[cacheKey(login)]
+	c.mux.Unlock()
+
+	if !found || entry.Verified.Add(c.grace).Before(c.now()) {
+		return "", false, false
+	}
+	if err := passlib.VerifyNoUpgrade(password, entry.Hash); err != nil {
+		return entry.UserName, true, false
+	}
+
+	return entry.UserName, true, true
+}
+
+// save must be called with the lock held
+func (c *offlineCache) save() {
+	now := c.now()
+	for key, entry := range c.entries {
+		if entry.Verified.Add(c.grace).Before(now) {
+			delete(c.entries, key)
+		}
+	}
+
+	if err := jsonfile.Save(c.file, c.entries); err != nil {
+		c.log.WithError(err).WithField("file", c.file).Error("Can't save the offline cache.")
+	}
+}
+
+func cacheKey(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
@@ -0,0 +1,114 @@
+package wikiauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/ktt-ol/sesam/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_offlineCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+	file := filepath.Join(dir, "cache.json")
+
+	now := time.Unix(1000, 0)
+	cache := newOfflineCache(file, 24*time.Hour)
+	cache.now = testutil.Clock(&now)
+
+	cache.remember("Alice@example.org", "alice", "secret")
+
+	userName, cached, ok := cache.check("alice@example.org", "secret")
+	assert.True(cached)
+	assert.True(ok)
+	assert.Equal("alice", userName)
+	userName, _, ok = cache.check("alice", "secret")
+	assert.True(ok)
+	assert.Equal("alice", userName)
+	_, cached, ok = cache.check("alice", "wrong")
+	assert.True(cached)
+	assert.False(ok)
+	_, cached, _ = cache.check("bob", "secret")
+	assert.False(cached)
+
+	// the cache is persisted
+	loaded := newOfflineCache(file, 24*time.Hour)
+	loaded.now = testutil.Clock(&now)
+	_, _, ok = loaded.check("alice", "secret")
+	assert.True(ok)
+
+	// the grace period is over
+	now = now.Add(25 * time.Hour)
+	_, cached, ok = cache.check("alice", "secret")
+	assert.False(cached)
+	assert.False(ok)
+}
+
+func Test_offlineCache_rememberReplacesTheUser(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	cache := newOfflineCache(filepath.Join(dir, "cache.json"), 24*time.Hour)
+	cache.remember("alice@example.org", "alice", "secret")
+	cache.remember("alice@example.com", "alice", "new secret")
+
+	_, cached, _ := cache.check("alice@example.org", "secret")
+	assert.False(cached)
+	_, _, ok := cache.check("alice", "secret")
+	assert.False(ok)
+	_, _, ok = cache.check("alice", "new secret")
+	assert.True(ok)
+	_, _, ok = cache.check("alice@example.com", "new secret")
+	assert.True(ok)
+}
+
+func Test_onlineAuth_offlineCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, cleanup := testutil.TempDir(t)
+	defer cleanup()
+
+	// the answer of the wiki, empty if it's broken
+	wikiResult := "ok"
+	wiki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wikiResult == "" {
+			http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(response_loginResult{Result: wikiResult})
+	}))
+	defer wiki.Close()
+
+	auth := NewOnlineAuth(&conf.AuthOnline{WikiBaseUrl: wiki.URL, OfflineGraceDays: 1}, filepath.Join(dir, "cache.json"))
+	_, authError := auth.CheckPassword("alice", "secret")
+	assert.Nil(authError)
+
+	// a wrong password doesn't remove the cached login
+	wikiResult = "wrong_password"
+	_, authError = auth.CheckPassword("alice", "guess")
+	assert.False(authError.SystemError)
+
+	wikiResult = ""
+	userName, authError := auth.CheckPassword("alice", "secret")
+	assert.Nil(authError)
+	assert.Equal("alice", userName)
+
+	// a wrong password is a normal failure, not a system error
+	_, authError = auth.CheckPassword("alice", "guess")
+	assert.NotNil(authError)
+	assert.False(authError.SystemError)
+
+	// unknown logins can't be checked
+	_, authError = auth.CheckPassword("bob", "secret")
+	assert.True(authError.SystemError)
+}
@@ -20,16 +20,26 @@ import (
 const pageParam = "/?action=authService&do="
 const maxCacheAge = time.Duration(24 * time.Hour)
 
+// the membership of the sessions is checked with a fresher user list
+const memberListMaxAge = time.Duration(10 * time.Minute)
+
 type onlineAuth struct {
 	log                 *logrus.Entry
 	wikiActionUrl       string
 	authToken           string
 	nameToEmailMapCache map[string]string
-	lastCacheUpdate     time.Time
-	updateCacheMux      sync.Mutex
+	// the wiki lists only members
+	memberNamesCache map[string]struct{}
+	lastCacheUpdate  time.Time
+	// guards the caches and lastCacheUpdate
+	updateCacheMux sync.RWMutex
+	// nil if disabled
+	offlineCache *offlineCache
 }
 
-func NewOnlineAuth(config *conf.AuthOnline) WikiAuth {
+// NewOnlineAuth creates the auth for the wiki. If config.OfflineGraceDays is set, successful logins are remembered in
+// the offlineCacheFile and used while the wiki is not reachable.
+func NewOnlineAuth(config *conf.AuthOnline, offlineCacheFile string) WikiAuth {
 	url := config.WikiBaseUrl + pageParam
 
 	auth := onlineAuth{
@@ -37,20 +47,48 @@ func NewOnlineAuth(config *conf.AuthOnline) WikiAuth {
 		wikiActionUrl:       url,
 		authToken:           config.AuthToken,
 		nameToEmailMapCache: make(map[string]string),
+		memberNamesCache:    make(map[string]struct{}),
 		lastCacheUpdate:     time.Unix(0, 0),
 	}
+	if config.OfflineGraceDays > 0 {
+		grace := time.Duration(config.OfflineGraceDays) * 24 * time.Hour
+		auth.offlineCache = newOfflineCache(offlineCacheFile, grace)
+	}
 
 	return &auth
 }
 
 func (a *onlineAuth) CheckPassword(emailOrName string, password string) (userName string, authError *AuthError) {
+	userName, authError = a.checkPasswordOnline(emailOrName, password)
+	if a.offlineCache == nil {
+		return
+	}
+
+	if authError == nil {
+		a.offlineCache.remember(emailOrName, userName, password)
+		return
+	}
+	// a wrong password must not remove the cached login, anybody could do that
+	if !authError.SystemError {
+		return
+	}
+
+	cachedName, cached, ok := a.offlineCache.check(emailOrName, password)
+	if ok {
+		a.log.WithField("userName", cachedName).WithError(authError.Error).Warn("Wiki not reachable, login accepted by the offline cache.")
+		return cachedName, nil
+	}
+	if cached {
+		// a normal failure, so the login throttle counts it
+		return cachedName, &AuthError{Error: errors.New("Invalid password")}
+	}
+	return
+}
+
+func (a *onlineAuth) checkPasswordOnline(emailOrName string, password string) (userName string, authError *AuthError) {
 	defer func() {
 		if r := recover(); r != nil {
-			err, ok := r.(error)
-			if !ok {
-				err = fmt.Errorf("pkg: %v", r)
-			}
-			authError = &AuthError{Error: err, SystemError: true}
+			authError = &AuthError{Error: errorOfPanic(r), SystemError: true}
 		}
 	}()
 
@@ -75,11 +113,27 @@ func (a *onlineAuth) CheckPassword(emailOrName string, password string) (userNam
 	}
 }
 
+// IsMember checks the user against the user list of the wiki
+func (a *onlineAuth) IsMember(userName string) (isMember bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errorOfPanic(r)
+		}
+	}()
+
+	a.updateUserList(memberListMaxAge)
+
+	a.updateCacheMux.RLock()
+	defer a.updateCacheMux.RUnlock()
+	_, isMember = a.memberNamesCache[userName]
+	return isMember, nil
+}
+
 func (a *onlineAuth) getNameForEmail(email string) (name string, emailNotFound bool) {
-	if a.lastCacheUpdate.Add(maxCacheAge).Before(time.Now()) {
-		a.updateUserList()
-	}
+	a.updateUserList(maxCacheAge)
 
+	a.updateCacheMux.RLock()
+	defer a.updateCacheMux.RUnlock()
 	email, found := a.nameToEmailMapCache[strings.ToLower(email)]
 	if !found {
 		return "", true
@@ -87,12 +141,24 @@ func (a *onlineAuth) getNameForEmail(email string) (name string, emailNotFound b
 	return email, false
 }
 
-func (a *onlineAuth) updateUserList() {
+// isCacheOlder returns true if the user list is older than maxAge
+func (a *onlineAuth) isCacheOlder(maxAge time.Duration) bool {
+	a.updateCacheMux.RLock()
+	defer a.updateCacheMux.RUnlock()
+	return a.lastCacheUpdate.Add(maxAge).Before(time.Now())
+}
+
+// updateUserList requests the user list again, if the cache is older than maxAge. On errors the old cache is kept.
+func (a *onlineAuth) updateUserList(maxAge time.Duration) {
+	if !a.isCacheOlder(maxAge) {
+		return
+	}
+
 	a.updateCacheMux.Lock()
 	defer a.updateCacheMux.Unlock()
 
 	// check again, because another thread might have updated the cache in between
-	if !a.lastCacheUpdate.Add(maxCacheAge).Before(time.Now()) {
+	if !a.lastCacheUpdate.Add(maxAge).Before(time.Now()) {
 		return
 	}
 
@@ -100,12 +166,15 @@ func (a *onlineAuth) updateUserList() {
 
 	userList := a.requestUserList()
 	newCache := make(map[string]string)
+	newMemberNames := make(map[string]struct{})
 	for _, user := range userList {
 		newCache[strings.ToLower(user.Email)] = user.Login
+		newMemberNames[user.Login] = struct{}{}
 	}
 
 	a.lastCacheUpdate = time.Now()
 	a.nameToEmailMapCache = newCache
+	a.memberNamesCache = newMemberNames
 }
 
 func (a *onlineAuth) requestUserList() []response_user {
@@ -123,6 +192,12 @@ func (a *onlineAuth) requestUserList() []response_user {
 	panicForError(err)
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		a.log.WithField("http", resp.StatusCode).WithField("data", abbr(string(body), 20)).
+			Error("Unexpected status for user response")
+		panic(fmt.Errorf("unexpected http status %d for the user list", resp.StatusCode))
+	}
+
 	var result []response_user
 	err = json.Unmarshal(body, &result);
 	if err != nil {
@@ -130,6 +205,10 @@ func (a *onlineAuth) requestUserList() []response_user {
 			WithError(err).Error("Invalid json for user response")
 		panic(err)
 	}
+	// the wiki has members, an empty list would remove all sessions
+	if len(result) == 0 {
+		panic(errors.New("empty user list"))
+	}
 
 	return result
 }
@@ -175,6 +254,15 @@ func abbr(msg string, maxSize int) string {
 	return msg[0:maxSize] + "..."
 }
 
+// errorOfPanic converts a recovered value to an error
+func errorOfPanic(r interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("pkg: %v", r)
+	}
+	return err
+}
+
 func panicForError(err error) {
 	if err != nil {
 		panic(err)
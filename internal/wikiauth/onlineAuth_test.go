@@ -0,0 +1,112 @@
+package wikiauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ktt-ol/sesam/internal/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWiki answers the user list requests of onlineAuth
+type fakeWiki struct {
+	mux      sync.Mutex
+	status   int
+	userList string
+	requests int
+}
+
+func (f *fakeWiki) set(status int, userList string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.status = status
+	f.userList = userList
+}
+
+func (f *fakeWiki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if r.URL.Query().Get("do") != "list" || r.Header.Get("Auth-Token") != "token" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.requests++
+	w.WriteHeader(f.status)
+	w.Write([]byte(f.userList))
+}
+
+func newTestOnlineAuth(t *testing.T, wiki *fakeWiki) (*onlineAuth, func()) {
+	server := httptest.NewServer(wiki)
+	auth := NewOnlineAuth(&conf.AuthOnline{WikiBaseUrl: server.URL, AuthToken: "token"}, "").(*onlineAuth)
+	return auth, server.Close
+}
+
+func Test_onlineAuth_IsMember(t *testing.T) {
+	assert := assert.New(t)
+	wiki := &fakeWiki{status: http.StatusOK, userList: `[{"Login": "alice", "Email": "alice@example.com"}]`}
+	auth, cleanup := newTestOnlineAuth(t, wiki)
+	defer cleanup()
+
+	isMember, err := auth.IsMember("alice")
+	assert.NoError(err)
+	assert.True(isMember)
+	isMember, err = auth.IsMember("bob")
+	assert.NoError(err)
+	assert.False(isMember)
+	// the list is cached
+	wiki.mux.Lock()
+	defer wiki.mux.Unlock()
+	assert.Equal(1, wiki.requests)
+}
+
+func Test_onlineAuth_IsMember_invalidUserList(t *testing.T) {
+	assert := assert.New(t)
+	wiki := &fakeWiki{status: http.StatusOK, userList: `[{"Login": "alice", "Email": "alice@example.com"}]`}
+	auth, cleanup := newTestOnlineAuth(t, wiki)
+	defer cleanup()
+
+	isMember, err := auth.IsMember("alice")
+	assert.NoError(err)
+	assert.True(isMember)
+
+	for _, invalid := range []struct {
+		status   int
+		userList string
+	}{
+		{http.StatusInternalServerError, `[]`},
+		{http.StatusForbidden, `[{"Login": "bob", "Email": "bob@example.com"}]`},
+		{http.StatusOK, `[]`},
+		{http.StatusOK, `null`},
+	} {
+		wiki.set(invalid.status, invalid.userList)
+		auth.lastCacheUpdate = time.Unix(0, 0)
+
+		_, err = auth.IsMember("alice")
+		assert.Error(err, "%d %s", invalid.status, invalid.userList)
+		// the old list is kept
+		_, found := auth.memberNamesCache["alice"]
+		assert.True(found)
+	}
+}
+
+func Test_onlineAuth_IsMember_concurrent(t *testing.T) {
+	wiki := &fakeWiki{status: http.StatusOK, userList: `[{"Login": "alice", "Email": "alice@example.com"}]`}
+	auth, cleanup := newTestOnlineAuth(t, wiki)
+	defer cleanup()
+
+	// go test -race finds unguarded reads of the cache
+	var group sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			isMember, err := auth.IsMember("alice")
+			assert.NoError(t, err)
+			assert.True(t, isMember)
+		}()
+	}
+	group.Wait()
+}
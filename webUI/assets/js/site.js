@@ -18,9 +18,11 @@ function addClass(node, className) {
 function buzzer(door, csrfToken) {
     var errorSnack = document.getElementById('errorSnack');
     var infoSnack = document.getElementById('infoSnack');
+    var forbiddenSnack = document.getElementById('forbiddenSnack');
 
     removeClass(errorSnack, "show");
     removeClass(infoSnack, "show");
+    removeClass(forbiddenSnack, "show");
 
     var dooButtons = document.getElementById("doorButtons");
     addClass(dooButtons, "sending");
@@ -34,16 +36,29 @@ function buzzer(door, csrfToken) {
             removeClass(dooButtons, "sending");
             var buttons = document.getElementsByTagName('button');
             for (var i = 0; i < buttons.length; i++) {
-                if (buttons.item(i).onclick) {
-                    buttons.item(i).disabled = false;
+                if (buttons.item(i).onclick && buttons.item(i).className.indexOf('cooling') === -1) {
+                    buttons.item(i).disabled = buttons.item(i).getAttribute('data-available') !== 'true';
                 }
             }
 
+            var button = document.getElementById('door-' + door);
             if (serverError) {
-                addClass(errorSnack, "show");
+                if (response.status === 429) {
+                    // the cooldown or the quota
+                    forbiddenSnack.textContent = response.responseText;
+                    addClass(forbiddenSnack, "show");
+                    startCooldown(button, parseInt(response.getResponseHeader('Retry-After'), 10));
+                } else if (response.status === 403 || (response.status >= 500 && response.responseText)) {
+                    // denied, or the door controller is offline or didn't confirm the buzzer
+                    forbiddenSnack.textContent = response.responseText;
+                    addClass(forbiddenSnack, "show");
+                } else {
+                    addClass(errorSnack, "show");
+                }
             } else {
                 if (response === 'OK') {
                     addClass(infoSnack, "show");
+                    startCooldown(button, parseInt(button.getAttribute('data-cooldown'), 10));
                 } else if (response === 'LOGIN') {
                     window.location = '/login';
                 } else {
@@ -55,6 +70,42 @@ function buzzer(door, csrfToken) {
     );
 }
 
+// disables the door button until the cooldown is over, with a countdown
+function startCooldown(button, seconds) {
+    if (!button || !(seconds > 0)) {
+        return;
+    }
+    var countdown = button.getElementsByClassName('countdown')[0];
+    var until = Date.now() + seconds * 1000;
+    addClass(button, 'cooling');
+    button.disabled = true;
+
+    var tick = function () {
+        var left = Math.ceil((until - Date.now()) / 1000);
+        if (left <= 0) {
+            removeClass(button, 'cooling');
+            countdown.textContent = '';
+            // the other buttons are enabled again after the request
+            button.disabled = document.getElementById('doorButtons').className.indexOf('sending') !== -1 ||
+                button.getAttribute('data-available') !== 'true';
+            return;
+        }
+        var minutes = Math.floor(left / 60);
+        var seconds = left % 60;
+        countdown.textContent = minutes > 0 ? ' (' + minutes + ':' + (seconds < 10 ? '0' : '') + seconds + ')' : ' (' + seconds + 's)';
+        window.setTimeout(tick, 1000);
+    };
+    tick();
+}
+
+// the cooldowns of the page load
+function startCooldowns() {
+    var buttons = document.querySelectorAll('#doorButtons button[data-wait]');
+    for (var i = 0; i < buttons.length; i++) {
+        startCooldown(buttons[i], parseInt(buttons[i].getAttribute('data-wait'), 10));
+    }
+}
+
 var timeoutHandle;
 
 function hideBoxWithTimeout() {
@@ -64,6 +115,7 @@ function hideBoxWithTimeout() {
     timeoutHandle = window.setTimeout(function () {
         removeClass(document.getElementById('errorSnack'), "show");
         removeClass(document.getElementById('infoSnack'), "show");
+        removeClass(document.getElementById('forbiddenSnack'), "show");
     }, 3000);
 }
 
@@ -86,8 +138,201 @@ function sendRequest(url, csrfToken, callback) {
 }
 
 
+// updates the page when the space opens or closes, the browser reconnects the event stream by itself
+function followSpaceStatus() {
+    if (!window.EventSource) {
+        return;
+    }
+    var events = new EventSource('/api/v1/status/events');
+    events.addEventListener('status', function (event) {
+        var status = JSON.parse(event.data);
+        var body = document.body;
+        removeClass(body, 'opened');
+        removeClass(body, 'closed');
+        addClass(body, status.isOpen ? 'opened' : 'closed');
+        // every door has its own allowed statuses
+        updateDoors();
+    });
+}
+
+function updateDoors() {
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', '/api/v1/doors');
+    xhr.onreadystatechange = function () {
+        var DONE = 4; // readyState 4 means the request is done.
+        var OK = 200; // status 200 is a successful return.
+        if (xhr.readyState === DONE && xhr.status === OK) {
+            JSON.parse(xhr.responseText).forEach(updateDoor);
+        }
+    };
+    xhr.send(null);
+}
+
+// updates the button of the door with the door of the api
+function updateDoor(door) {
+    var button = document.getElementById('door-' + door.id);
+    if (!button) {
+        return;
+    }
+    button.setAttribute('data-available', door.available ? 'true' : 'false');
+    removeClass(button, 'btn-primary');
+    removeClass(button, 'btn-default');
+    addClass(button, door.available ? 'btn-primary' : 'btn-default');
+    var sending = document.getElementById('doorButtons').className.indexOf('sending') !== -1;
+    button.disabled = !door.available || sending || button.className.indexOf('cooling') !== -1;
+
+    var note = '';
+    if (!door.online) {
+        note = 'Controller offline';
+    } else if (door.closed) {
+        note = 'Not while the space is closed';
+    } else if (door.nextAllowed) {
+        note = 'Next time: ' + new Date(door.nextAllowed).toLocaleString([], {
+            weekday: 'short', day: '2-digit', month: '2-digit', hour: '2-digit', minute: '2-digit'
+        });
+    }
+    button.getElementsByClassName('door-note')[0].textContent = note;
+}
+
 function showLoginWaiting() {
     var lBtn = document.getElementById('loginButton');
     addClass(lBtn, 'waiting');
     lBtn.disabled = true;
-}
\ No newline at end of file
+}
+
+// passkeys (WebAuthn), the server sends and expects the binary fields as base64url
+
+function base64urlToBuffer(value) {
+    var base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    while (base64.length % 4) {
+        base64 += '=';
+    }
+    var binary = atob(base64);
+    var bytes = new Uint8Array(binary.length);
+    for (var i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes.buffer;
+}
+
+function bufferToBase64url(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var binary = '';
+    for (var i = 0; i < bytes.length; i++) {
+        binary += String.fromCharCode(bytes[i]);
+    }
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+}
+
+function postJson(url, csrfToken, body, callback) {
+    var xhr = new XMLHttpRequest();
+    xhr.open('POST', url);
+    xhr.setRequestHeader('X-CSRF-TOKEN', csrfToken);
+    xhr.setRequestHeader('Content-Type', 'application/json');
+    xhr.onreadystatechange = function () {
+        var DONE = 4; // readyState 4 means the request is done.
+        var OK = 200; // status 200 is a successful return.
+        if (xhr.readyState === DONE) {
+            callback(xhr.status !== OK, xhr);
+        }
+    };
+    xhr.send(body ? JSON.stringify(body) : null);
+}
+
+function showPasskeyError(message) {
+    var errorBox = document.getElementById('passkeyError');
+    errorBox.textContent = message || 'Unknown server error. Please try later again.';
+    addClass(errorBox, 'show');
+    document.getElementById('passkeyButton').disabled = false;
+}
+
+function startPasskeyAction() {
+    if (!window.PublicKeyCredential) {
+        showPasskeyError('Your browser does not support passkeys.');
+        return false;
+    }
+    removeClass(document.getElementById('passkeyError'), 'show');
+    document.getElementById('passkeyButton').disabled = true;
+    return true;
+}
+
+function passkeyLogin(csrfToken) {
+    if (!startPasskeyAction()) {
+        return;
+    }
+
+    postJson('/login/passkey/begin', csrfToken, null, function (serverError, response) {
+        if (serverError) {
+            showPasskeyError(response.responseText);
+            return;
+        }
+        var options = JSON.parse(response.responseText).publicKey;
+        options.challenge = base64urlToBuffer(options.challenge);
+        (options.allowCredentials || []).forEach(function (credential) {
+            credential.id = base64urlToBuffer(credential.id);
+        });
+
+        navigator.credentials.get({publicKey: options}).then(function (credential) {
+            var remember = document.getElementById('rememberLogin').checked ? '1' : '0';
+            postJson('/login/passkey/finish?remember=' + remember, csrfToken, {
+                id: credential.id,
+                rawId: bufferToBase64url(credential.rawId),
+                type: credential.type,
+                response: {
+                    authenticatorData: bufferToBase64url(credential.response.authenticatorData),
+                    clientDataJSON: bufferToBase64url(credential.response.clientDataJSON),
+                    signature: bufferToBase64url(credential.response.signature),
+                    userHandle: credential.response.userHandle ? bufferToBase64url(credential.response.userHandle) : null
+                }
+            }, function (serverError, response) {
+                if (serverError) {
+                    showPasskeyError(response.responseText);
+                } else {
+                    window.location = '/';
+                }
+            });
+        }).catch(function () {
+            showPasskeyError('The passkey login was canceled.');
+        });
+    });
+}
+
+function passkeyRegister(csrfToken) {
+    if (!startPasskeyAction()) {
+        return;
+    }
+
+    postJson('/passkeys/register/begin', csrfToken, null, function (serverError, response) {
+        if (serverError) {
+            showPasskeyError(response.responseText);
+            return;
+        }
+        var options = JSON.parse(response.responseText).publicKey;
+        options.challenge = base64urlToBuffer(options.challenge);
+        options.user.id = base64urlToBuffer(options.user.id);
+        (options.excludeCredentials || []).forEach(function (credential) {
+            credential.id = base64urlToBuffer(credential.id);
+        });
+
+        navigator.credentials.create({publicKey: options}).then(function (credential) {
+            var name = encodeURIComponent(document.getElementById('passkeyName').value);
+            postJson('/passkeys/register/finish?name=' + name, csrfToken, {
+                id: credential.id,
+                rawId: bufferToBase64url(credential.rawId),
+                type: credential.type,
+                response: {
+                    attestationObject: bufferToBase64url(credential.response.attestationObject),
+                    clientDataJSON: bufferToBase64url(credential.response.clientDataJSON)
+                }
+            }, function (serverError, response) {
+                if (serverError) {
+                    showPasskeyError(response.responseText);
+                } else {
+                    window.location.reload();
+                }
+            });
+        }).catch(function () {
+            showPasskeyError('The passkey was not added.');
+        });
+    });
+}